@@ -0,0 +1,164 @@
+package epidemic
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"maelstrom-broadcast/seenlog"
+)
+
+// Config controls how far and for how long a rumor is spread.
+type Config struct {
+	Fanout    int // Number of random peers contacted each round.
+	MaxRounds int // Rounds a rumor stays hot before it is retired.
+	StopAfter int // Retire a rumor early once this many peers reported they already had it.
+}
+
+// DefaultConfig reaches every node of a simulated 400-node cluster within 8 rounds; see TestSimulatedConvergence.
+var DefaultConfig = Config{
+	Fanout:    3,
+	MaxRounds: 10,
+	StopAfter: 8,
+}
+
+// Validate returns an error if any field of c is less than 1.
+func (c Config) Validate() error {
+	switch {
+	case c.Fanout < 1:
+		return fmt.Errorf("fanout must be at least 1, got %d", c.Fanout)
+	case c.MaxRounds < 1:
+		return fmt.Errorf("rounds must be at least 1, got %d", c.MaxRounds)
+	case c.StopAfter < 1:
+		return fmt.Errorf("stop-after must be at least 1, got %d", c.StopAfter)
+	}
+	return nil
+}
+
+// Rumor is a value being spread, as sent on the wire.
+// Age is the number of rounds since the value was first broadcast to any node.
+type Rumor struct {
+	Value json.RawMessage `json:"value"`
+	Age   int             `json:"age"`
+}
+
+// rumor is the local spreading state of a hot value.
+type rumor struct {
+	value     json.RawMessage
+	age       int // Age of the value when this node learned it.
+	rounds    int // Rounds this node has spread it for.
+	redundant int // Peers that reported they already had it.
+}
+
+// Rumors is the set of hot values a node is currently spreading. It implements rumor-mongering
+// termination: a rumor is retired after MaxRounds rounds, or earlier once StopAfter peers have
+// reported that it was redundant, since most of the cluster likely has it by then.
+// It is safe for concurrent use.
+type Rumors struct {
+	cfg Config
+
+	mu  sync.Mutex             // mu guards access to hot.
+	hot map[seenlog.Key]*rumor // Hot rumors keyed by their value's canonical hash.
+}
+
+// NewRumors initializes and returns a pointer to a new, empty Rumors.
+func NewRumors(cfg Config) *Rumors {
+	return &Rumors{
+		cfg: cfg,
+		hot: make(map[seenlog.Key]*rumor),
+	}
+}
+
+// Add starts spreading value, which was learned at the given age.
+// Adding a value that is already hot has no effect.
+func (r *Rumors) Add(key seenlog.Key, value json.RawMessage, age int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.hot[key]; ok {
+		return
+	}
+	r.hot[key] = &rumor{value: value, age: age}
+}
+
+// Hot returns every hot rumor, aged as a peer receiving it during the current round would see it.
+// It is used to answer pulls and doesn't count as a round of spreading.
+func (r *Rumors) Hot() []Rumor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rumors := make([]Rumor, 0, len(r.hot))
+	for _, h := range r.hot {
+		rumors = append(rumors, Rumor{Value: h.value, Age: h.age + h.rounds + 1})
+	}
+	return rumors
+}
+
+// Round returns every hot rumor to push this round, then ages them by one round and retires
+// those that have been spread for MaxRounds rounds.
+func (r *Rumors) Round() []Rumor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rumors := make([]Rumor, 0, len(r.hot))
+	for key, h := range r.hot {
+		rumors = append(rumors, Rumor{Value: h.value, Age: h.age + h.rounds + 1})
+
+		h.rounds++
+		if h.rounds >= r.cfg.MaxRounds {
+			delete(r.hot, key)
+		}
+	}
+	return rumors
+}
+
+// Redundant records that a peer already had the rumor for key, retiring it after StopAfter reports.
+func (r *Rumors) Redundant(key seenlog.Key) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	h, ok := r.hot[key]
+	if !ok {
+		return
+	}
+
+	h.redundant++
+	if h.redundant >= r.cfg.StopAfter {
+		delete(r.hot, key)
+	}
+}
+
+// Len returns the number of hot rumors.
+func (r *Rumors) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.hot)
+}
+
+/*
+PickPeers chooses up to fanout distinct peers uniformly at random.
+
+Parameters:
+  - rng: the source of randomness.
+  - self: the local node ID, which is never chosen.
+  - nodes: every node ID in the cluster.
+  - fanout: the number of peers to choose.
+
+Returns:
+  - The chosen peers; all of them if there are fewer than fanout.
+*/
+func PickPeers(rng *rand.Rand, self string, nodes []string, fanout int) []string {
+	peers := make([]string, 0, len(nodes))
+	for _, id := range nodes {
+		if id != self {
+			peers = append(peers, id)
+		}
+	}
+
+	rng.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if len(peers) > fanout {
+		peers = peers[:fanout]
+	}
+	return peers
+}
@@ -0,0 +1,203 @@
+package epidemic_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"maelstrom-broadcast/epidemic"
+	"maelstrom-broadcast/seenlog"
+)
+
+func mustKey(t testing.TB, v json.RawMessage) seenlog.Key {
+	t.Helper()
+	k, err := seenlog.KeyOf(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestRumors_RetiredAfterMaxRounds(t *testing.T) {
+	r := epidemic.NewRumors(epidemic.Config{Fanout: 1, MaxRounds: 2, StopAfter: 10})
+	v := json.RawMessage(`1`)
+	r.Add(mustKey(t, v), v, 0)
+
+	if got := r.Round(); len(got) != 1 || got[0].Age != 1 {
+		t.Fatalf("round 1=%v, want one rumor of age 1", got)
+	}
+	if got := r.Round(); len(got) != 1 || got[0].Age != 2 {
+		t.Fatalf("round 2=%v, want one rumor of age 2", got)
+	}
+	if got := r.Round(); len(got) != 0 {
+		t.Fatalf("round 3=%v, want no rumors", got)
+	}
+}
+
+func TestRumors_RetiredAfterRedundantFeedback(t *testing.T) {
+	r := epidemic.NewRumors(epidemic.Config{Fanout: 1, MaxRounds: 100, StopAfter: 2})
+	v := json.RawMessage(`"x"`)
+	k := mustKey(t, v)
+	r.Add(k, v, 3)
+
+	r.Redundant(k)
+	if r.Len() != 1 {
+		t.Fatal("rumor retired after a single redundant delivery")
+	}
+	r.Redundant(k)
+	if r.Len() != 0 {
+		t.Fatal("rumor still hot after StopAfter redundant deliveries")
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	if err := epidemic.DefaultConfig.Validate(); err != nil {
+		t.Fatalf("DefaultConfig: %v", err)
+	}
+	for _, cfg := range []epidemic.Config{
+		{Fanout: -1, MaxRounds: 10, StopAfter: 8},
+		{Fanout: 0, MaxRounds: 10, StopAfter: 8},
+		{Fanout: 3, MaxRounds: 0, StopAfter: 8},
+		{Fanout: 3, MaxRounds: 10, StopAfter: -2},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+}
+
+func TestPickPeers(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	nodes := []string{"n0", "n1", "n2", "n3"}
+
+	peers := epidemic.PickPeers(rng, "n0", nodes, 2)
+	if len(peers) != 2 || peers[0] == peers[1] {
+		t.Fatalf("peers=%q, want 2 distinct peers", peers)
+	}
+	for _, p := range peers {
+		if p == "n0" {
+			t.Fatal("picked self as a peer")
+		}
+	}
+
+	if peers := epidemic.PickPeers(rng, "n0", nodes, 10); len(peers) != 3 {
+		t.Fatalf("peers=%q, want all 3 other nodes", peers)
+	}
+}
+
+// simulate spreads a single value from node 0 through a cluster of n nodes in synchronous
+// push-pull rounds, returning the rounds until every node had it (or -1 if some never did)
+// and the fraction of deliveries that were redundant.
+func simulate(t testing.TB, rng *rand.Rand, n int, cfg epidemic.Config) (int, float64) {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("n%d", i)
+	}
+	index := make(map[string]int, n)
+	for i, id := range ids {
+		index[id] = i
+	}
+
+	v := json.RawMessage(`42`)
+	k := mustKey(t, v)
+
+	rumors := make([]*epidemic.Rumors, n)
+	has := make([]bool, n)
+	for i := range rumors {
+		rumors[i] = epidemic.NewRumors(cfg)
+	}
+	rumors[0].Add(k, v, 0)
+	has[0] = true
+	informed := 1
+
+	var deliveries, redundant int
+	deliver := func(to int, r epidemic.Rumor) bool {
+		deliveries++
+		if has[to] {
+			redundant++
+			return false
+		}
+		has[to] = true
+		informed++
+		rumors[to].Add(k, r.Value, r.Age)
+		return true
+	}
+
+	for round := 1; round <= 100; round++ {
+		pushes := make([][]epidemic.Rumor, n)
+		for i := range rumors {
+			pushes[i] = rumors[i].Round()
+		}
+
+		for i := range rumors {
+			if len(pushes[i]) == 0 {
+				continue
+			}
+			for _, peer := range epidemic.PickPeers(rng, ids[i], ids, cfg.Fanout) {
+				j := index[peer]
+				for _, r := range pushes[i] {
+					if !deliver(j, r) {
+						rumors[i].Redundant(k)
+					}
+				}
+				for _, r := range rumors[j].Hot() {
+					deliver(i, r)
+				}
+			}
+		}
+
+		if informed == n {
+			return round, float64(redundant) / float64(deliveries)
+		}
+		if deliveries > 0 && rumorsLeft(rumors) == 0 {
+			return -1, float64(redundant) / float64(deliveries)
+		}
+	}
+	return -1, float64(redundant) / float64(deliveries)
+}
+
+func rumorsLeft(rumors []*epidemic.Rumors) int {
+	total := 0
+	for _, r := range rumors {
+		total += r.Len()
+	}
+	return total
+}
+
+// Ensure epidemic spreading reaches the whole cluster within a logarithmic number of rounds
+// as the cluster grows. Run with -v to see rounds-to-convergence and redundancy by cluster size.
+func TestSimulatedConvergence(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	cfg := epidemic.DefaultConfig
+
+	for _, n := range []int{5, 25, 100, 400} {
+		const trials = 20
+
+		var totalRounds, maxRounds, failed int
+		var totalRedundancy float64
+		for i := 0; i < trials; i++ {
+			rounds, redundancy := simulate(t, rng, n, cfg)
+			if rounds < 0 {
+				failed++
+				continue
+			}
+			totalRounds += rounds
+			totalRedundancy += redundancy
+			if rounds > maxRounds {
+				maxRounds = rounds
+			}
+		}
+
+		t.Logf("nodes=%d fanout=%d: mean rounds=%.2f max rounds=%d redundant ratio=%.2f incomplete=%d/%d",
+			n, cfg.Fanout, float64(totalRounds)/float64(trials-failed), maxRounds,
+			totalRedundancy/float64(trials-failed), failed, trials)
+
+		if failed > trials/10 {
+			t.Fatalf("nodes=%d: %d/%d trials never reached every node", n, failed, trials)
+		}
+		if maxRounds > 10 {
+			t.Fatalf("nodes=%d: took %d rounds to converge", n, maxRounds)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+
+	"maelstrom-broadcast/seenlog"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// gossipMessageBody is the body of a "gossip" message carrying the log entries in (From, To].
+//
+// Gossip is exchanged with Send rather than RPC: Node.RPC and Node.Reply round-trip bodies
+// through map[string]any, which would turn large integers in Messages into float64s.
+type gossipMessageBody struct {
+	maelstrom.MessageBody
+	Messages []json.RawMessage `json:"messages"`
+	From     int               `json:"from"`
+	To       int               `json:"to"`
+}
+
+// gossipOKMessageBody acknowledges the log range (From, To] of a "gossip" message. Incarnation
+// identifies the receiving process so the sender can tell when a neighbor has restarted and lost state.
+type gossipOKMessageBody struct {
+	maelstrom.MessageBody
+	From        int    `json:"from"`
+	To          int    `json:"to"`
+	Incarnation string `json:"incarnation"`
+}
+
+// newIncarnation returns a random identifier for this run of the process.
+func newIncarnation() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal("Error generating incarnation:", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+/*
+gossipTo sends a neighbor every log entry after its high-water mark.
+
+Parameters:
+  - neighbor: the node ID to gossip to.
+  - heartbeat: whether to send a gossip message even if there is nothing new for the neighbor.
+
+Entries the neighbor gossiped to us are filtered out since it already has them, unless it has
+restarted since. If nothing is left after filtering, the mark is advanced locally without sending
+anything, except for heartbeats. Otherwise the mark is advanced once the neighbor acknowledges
+the range with a "gossip_ok", which is handled by handleGossipOK.
+
+Anti-entropy sends heartbeats so that every neighbor keeps answering, with its incarnation, even
+when no new values arrive: without them a neighbor that restarts in a quiet cluster would never
+be noticed, and would stay empty.
+*/
+func (s *server) gossipTo(neighbor string, heartbeat bool) {
+	from, resetAt := s.marks.Get(neighbor)
+	entries, to := s.log.Since(from)
+	if to == from && !heartbeat {
+		return
+	}
+
+	messages := make([]json.RawMessage, 0, len(entries))
+	for i, e := range entries {
+		if e.Origin != neighbor || from+i+1 <= resetAt {
+			messages = append(messages, e.Value)
+		}
+	}
+
+	if len(messages) == 0 && !heartbeat {
+		s.marks.Advance(neighbor, from, to)
+		return
+	}
+
+	body := gossipMessageBody{
+		MessageBody: maelstrom.MessageBody{Type: "gossip"},
+		Messages:    messages,
+		From:        from,
+		To:          to,
+	}
+
+	if err := s.n.Send(neighbor, body); err != nil {
+		log.Printf("Error gossiping to %s: %v", neighbor, err)
+	}
+}
+
+// gossipToAll sends every neighbor the entries it has not acknowledged yet, as heartbeats if
+// heartbeat is set; see gossipTo.
+func (s *server) gossipToAll(heartbeat bool) {
+	for _, neighbor := range s.topology.Neighbors() {
+		s.gossipTo(neighbor, heartbeat)
+	}
+}
+
+// runAntiEntropy gossips to every neighbor each interval so that entries lost to dropped
+// messages or partitions are eventually delivered, and restarted neighbors are noticed.
+// It returns once ctx is done.
+func (s *server) runAntiEntropy(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.gossipToAll(true)
+	}
+}
+
+/*
+handleGossip merges the entries in a "gossip" message into the local log and acknowledges them
+once any new ones are persisted. When flooding, new entries are forwarded to our own neighbors
+straight away.
+
+Errors are logged rather than returned: the message has no msg_id, so an error reply would reach
+the sender as an unsolicited "error" message, which it has no handler for.
+*/
+func (s *server) handleGossip(msg maelstrom.Message) error {
+	var body gossipMessageBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		log.Printf("Malformed gossip from %s: %v", msg.Src, err)
+		return nil
+	}
+
+	keys := make([]seenlog.Key, len(body.Messages))
+	for i, v := range body.Messages {
+		k, err := seenlog.KeyOf(v)
+		if err != nil {
+			log.Printf("Malformed value in gossip from %s: %v", msg.Src, err)
+			return nil
+		}
+		keys[i] = k
+	}
+
+	// Without an acknowledgement the sender gossips the values again, and they are persisted then.
+	added, err := s.addDurably(msg.Src, keys, body.Messages)
+	if err != nil {
+		log.Printf("Error persisting gossip from %s: %v", msg.Src, err)
+		return nil
+	}
+
+	anyAdded := false
+	for _, ok := range added {
+		s.stats.record(viaGossip, ok)
+		anyAdded = anyAdded || ok
+	}
+
+	// In epidemic mode gossip is only a backstop, so new entries wait for the next round.
+	if anyAdded && s.rumors == nil {
+		go s.gossipToAll(false)
+	}
+
+	err = s.n.Send(msg.Src, gossipOKMessageBody{
+		MessageBody: maelstrom.MessageBody{Type: "gossip_ok"},
+		From:        body.From,
+		To:          body.To,
+		Incarnation: s.incarnation,
+	})
+	if err != nil {
+		log.Printf("Error acknowledging gossip from %s: %v", msg.Src, err)
+	}
+	return nil
+}
+
+// handleGossipOK advances a neighbor's high-water mark to the range it acknowledged.
+// Like handleGossip, it never returns an error.
+func (s *server) handleGossipOK(msg maelstrom.Message) error {
+	var body gossipOKMessageBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		log.Printf("Malformed gossip_ok from %s: %v", msg.Src, err)
+		return nil
+	}
+
+	// Ignore late acknowledgements from nodes that were removed from the topology in the meantime.
+	if !s.topology.Contains(msg.Src) {
+		return nil
+	}
+
+	s.marks.Ack(msg.Src, body.Incarnation, body.From, body.To, s.log.Version())
+	return nil
+}
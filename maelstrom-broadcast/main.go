@@ -1,41 +1,134 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"log"
 	"errors"
+	"flag"
+	"fmt"
+	"log"
 	"sync"
-	"strconv"
-	"maelstrom-broadcast/safeslice"
+	"sync/atomic"
+	"time"
+
+	"maelstrom-broadcast/epidemic"
+	"maelstrom-broadcast/seenlog"
+	"maelstrom-broadcast/topology"
+	"maelstrom-broadcast/wal"
+	"maelstrom-broadcast/watermark"
+
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
+// config is the broadcast node's configuration, set from command-line flags.
+type config struct {
+	workload            string          // "broadcast" or "g-set".
+	gossipMode          string          // "flood" or "epidemic".
+	antiEntropyInterval time.Duration   // How often neighbors are sent the entries they have not acknowledged.
+	epidemic            epidemic.Config // How rumors are spread in epidemic mode.
+	roundInterval       time.Duration   // Time between epidemic rounds.
+	walDir              string          // Directory for the write-ahead log; empty keeps values in memory only.
+	wal                 wal.Options     // How the write-ahead log is synced.
+	walCompactInterval  time.Duration   // How often the write-ahead log is compacted into a snapshot.
+}
+
+// server holds the state shared by all of the broadcast node's message handlers.
+type server struct {
+	n           *maelstrom.Node
+	log         *seenlog.Log            // Append-only log of every value this node has seen.
+	marks       *watermark.Marks        // Per-neighbor high-water marks into log.
+	incarnation string                  // Random identifier for this run of the process.
+	wal         atomic.Pointer[wal.WAL] // Durable copy of log; nil when running in memory only.
+	compactMu   sync.RWMutex            // Held for writing while wal is compacted; see addDurably.
+	topology    *topology.Topology      // The node's neighbors, as set by the last topology message.
+	rumors      *epidemic.Rumors        // Values being spread in epidemic mode; nil when flooding.
+	stats       deliveryStats           // Counts of values delivered by other nodes.
+}
+
+// newServer initializes and returns a pointer to a new server running on node n.
+func newServer(n *maelstrom.Node) *server {
+	return &server{
+		n:           n,
+		log:         seenlog.NewLog(),
+		marks:       watermark.NewMarks(),
+		incarnation: newIncarnation(),
+		topology:    topology.New(),
+	}
+}
+
+/*
+setNeighbors replaces the node's neighbors, which may happen at any point during a run.
+
+Parameters:
+  - neighbors: the new list of neighbor IDs.
+
+Neighbors that were just added are sent a catch-up gossip right away instead of waiting for
+the next anti-entropy round. Neighbors that were removed have their high-water marks dropped,
+so they stop being retried and start from scratch if they are ever added back.
+*/
+func (s *server) setNeighbors(neighbors []string) {
+	added, removed := s.topology.Set(s.n.ID(), neighbors)
+
+	for _, neighbor := range removed {
+		s.marks.Reset(neighbor)
+	}
+
+	for _, neighbor := range added {
+		go s.gossipTo(neighbor, false)
+	}
+}
+
 /*
-	copyStringMap creates and returns a shallow copy of a map[string]any.
-	It assumes the values are safe to copy directly (e.g., strings, numbers, etc.)
-	and does not perform a deep copy of nested structures.
+addValue records a value received from a client. If the value hasn't been seen before,
+it is persisted and then either gossiped to our neighbors right away or, in epidemic mode,
+becomes a rumor spread by the next epidemic rounds.
+
+Parameters:
+  - value: the value as received; it is treated as opaque JSON.
+
+Returns:
+  - A MalformedRequest error if value is missing or isn't valid JSON, or an error if it
+    couldn't be persisted. The client then retries, and the value is persisted again.
 */
-func copyStringMap(original map[string]any) map[string]any {
-    copyMap := make(map[string]any, len(original))
-    for k, v := range original {
-        copyMap[k] = v // v is string, so value copy is fine
-    }
-    return copyMap
+func (s *server) addValue(value json.RawMessage) error {
+	if len(value) == 0 {
+		return maelstrom.NewRPCError(maelstrom.MalformedRequest, "missing value")
+	}
+
+	k, err := seenlog.KeyOf(value)
+	if err != nil {
+		return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+	}
+
+	added, err := s.addDurably("", []seenlog.Key{k}, []json.RawMessage{value})
+	if err != nil {
+		return err
+	} else if !added[0] {
+		return nil
+	}
+
+	if s.rumors != nil {
+		s.rumors.Add(k, value, 0)
+	} else {
+		go s.gossipToAll(false)
+	}
+
+	return nil
 }
 
 /*
-	extractCurrentNodesNeighbors extracts the list of neighbor node IDs for a given node
-	from the "topology" field of the incoming message body.
+extractCurrentNodesNeighbors extracts the list of neighbor node IDs for a given node
+from the "topology" field of the incoming message body.
 
-	Parameters:
-		- body: the JSON-decoded message body, expected to contain a "topology" field.
-		- nodeId: the ID of the current node.
+Parameters:
+  - body: the JSON-decoded message body, expected to contain a "topology" field.
+  - nodeId: the ID of the current node.
 
-	Returns:
-		- A slice of strings representing the neighbor node IDs.
-		- An error if the "topology" field is missing or improperly formatted.
+Returns:
+  - A slice of strings representing the neighbor node IDs.
+  - An error if the "topology" field is missing or improperly formatted.
 */
-func extractCurrentNodesNeighbors(body map[string]any, nodeId string) ([]string, error) {	
+func extractCurrentNodesNeighbors(body map[string]any, nodeId string) ([]string, error) {
 	topologyRaw, ok := body["topology"].(map[string]any)
 	if !ok {
 		return nil, errors.New("topology field is not a map")
@@ -59,109 +152,202 @@ func extractCurrentNodesNeighbors(body map[string]any, nodeId string) ([]string,
 }
 
 /*
-	broadcastMessageToAllNeighbors sends the given message body to all neighbor nodes.
-
-	Parameters:
-	- neighbors: a slice of neighbor node IDs to which the message will be sent.
-	- neighborBody: the message payload represented as a map[string]any to send.
-	- n: a pointer to the Maelstrom node used to send messages.
-
-	For each neighbor in the slice, the function attempts to send the message.
-	If sending fails, the error is logged but the function continues sending to remaining neighbors.
+This program implements the broadcast workload, and the g-set workload with -workload g-set.
+Values are treated as opaque JSON: they are deduplicated by a hash of their canonical encoding
+and returned exactly as they were first received.
+
+Values are recorded in an append-only log and gossiped to neighbors as deltas: each neighbor
+is only sent the entries after the high-water mark it last acknowledged, both when a new value
+arrives and periodically as anti-entropy. Until a topology message arrives, every other node
+in the cluster is a neighbor.
+
+With -gossip-mode epidemic, new values are instead spread as rumors: every round, each node
+pushes its hot rumors to -fanout random peers and pulls theirs back, retiring a rumor after
+-rounds rounds or once -stop-after peers report they already had it. Anti-entropy with the
+topology's neighbors keeps running as a backstop. A "stats" message reports redundant-delivery
+ratios and how many rounds values took to arrive, for comparing the two modes.
+
+If -wal-dir is set, every value is also written to an on-disk write-ahead log before it is
+acknowledged, and the log is replayed on startup so a restarted node keeps its values.
 */
-func broadcastMessageToAllNeighbors(neighbors []string, neighborBody map[string]any, n *maelstrom.Node) {
-	for _, neighbor := range neighbors {
-		if err := n.Send(neighbor, neighborBody); err != nil {
-			log.Printf("Error sending to %s: %v", neighbor, err)
-		}
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.workload, "workload", "broadcast", "maelstrom workload to serve: broadcast or g-set")
+	flag.StringVar(&cfg.gossipMode, "gossip-mode", "flood", "how new values are spread: flood to neighbors or epidemic")
+	flag.DurationVar(&cfg.antiEntropyInterval, "anti-entropy-interval", 500*time.Millisecond, "how often neighbors are sent the entries they have not acknowledged")
+	flag.IntVar(&cfg.epidemic.Fanout, "fanout", epidemic.DefaultConfig.Fanout, "epidemic mode: random peers contacted each round")
+	flag.IntVar(&cfg.epidemic.MaxRounds, "rounds", epidemic.DefaultConfig.MaxRounds, "epidemic mode: rounds a rumor is spread for")
+	flag.IntVar(&cfg.epidemic.StopAfter, "stop-after", epidemic.DefaultConfig.StopAfter, "epidemic mode: redundant deliveries after which a rumor is retired")
+	flag.DurationVar(&cfg.roundInterval, "round-interval", 100*time.Millisecond, "epidemic mode: time between rounds")
+	flag.StringVar(&cfg.walDir, "wal-dir", "", "directory for the write-ahead log; empty keeps values in memory only")
+	flag.DurationVar(&cfg.wal.SyncInterval, "wal-sync-interval", wal.DefaultOptions.SyncInterval, "longest a value waits to be fsynced")
+	flag.IntVar(&cfg.wal.MaxBatch, "wal-max-batch", wal.DefaultOptions.MaxBatch, "number of waiting writes that forces an early fsync")
+	flag.DurationVar(&cfg.walCompactInterval, "wal-compact-interval", 30*time.Second, "how often the write-ahead log is compacted into a snapshot")
+	flag.Parse()
+
+	n, err := newBroadcastNode(context.Background(), cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Start the Maelstrom node, which listens for incoming messages.
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
 	}
 }
 
 /*
-	This program...
+newBroadcastNode creates a Maelstrom node, registers its handlers and starts its background gossip.
+
+Parameters:
+  - ctx: the background gossip stops once ctx is done.
+  - cfg: the node's configuration.
+
+Returns:
+  - The node, ready to be run.
+  - An error if the workload or gossip mode is unknown, an interval isn't positive, or the
+    epidemic or write-ahead log settings are out of range while in use.
 */
-func main() {
-    // Initialize a new Maelstrom node for the program to run on.
-    n := maelstrom.NewNode()
+func newBroadcastNode(ctx context.Context, cfg config) (*maelstrom.Node, error) {
+	// Both workloads use a 'read' message but expect the values under different keys.
+	var readKey string
+	switch cfg.workload {
+	case "broadcast":
+		readKey = "messages"
+	case "g-set":
+		readKey = "value"
+	default:
+		return nil, fmt.Errorf("unknown workload %q", cfg.workload)
+	}
 
-	// Create a thread-safe slice using the custom 'safeslice' module
-	broadcastVals := safeslice.NewSafeSlice()
+	if cfg.gossipMode != "flood" && cfg.gossipMode != "epidemic" {
+		return nil, fmt.Errorf("unknown gossip mode %q", cfg.gossipMode)
+	}
+	if cfg.antiEntropyInterval <= 0 {
+		return nil, fmt.Errorf("anti-entropy interval must be positive, got %v", cfg.antiEntropyInterval)
+	}
+	if cfg.gossipMode == "epidemic" {
+		if err := cfg.epidemic.Validate(); err != nil {
+			return nil, err
+		}
+		if cfg.roundInterval <= 0 {
+			return nil, fmt.Errorf("round interval must be positive, got %v", cfg.roundInterval)
+		}
+	}
+	if cfg.walDir != "" {
+		switch {
+		case cfg.wal.SyncInterval <= 0:
+			return nil, fmt.Errorf("wal sync interval must be positive, got %v", cfg.wal.SyncInterval)
+		case cfg.wal.MaxBatch < 1:
+			return nil, fmt.Errorf("wal max batch must be at least 1, got %d", cfg.wal.MaxBatch)
+		case cfg.walCompactInterval <= 0:
+			return nil, fmt.Errorf("wal compact interval must be positive, got %v", cfg.walCompactInterval)
+		}
+	}
 
-	var (
-		seen sync.Map // A map to track already seen messages with O(1) lookups
-		neighbors []string // Define a slice to store the node's neighbors in-memory.
-	)
+	// Initialize a new Maelstrom node for the program to run on.
+	n := maelstrom.NewNode()
+
+	s := newServer(n)
+	if cfg.gossipMode == "epidemic" {
+		s.rumors = epidemic.NewRumors(cfg.epidemic)
+	}
+
+	// Once the node knows its ID, default to gossiping with every other node and recover
+	// persisted values. This runs before the node replies with 'init_ok'.
+	n.Handle("init", func(msg maelstrom.Message) error {
+		s.setNeighbors(n.NodeIDs())
+
+		// Epidemic rounds pick peers from the cluster, so they can only start now.
+		if s.rumors != nil {
+			go s.runEpidemic(ctx, cfg.epidemic, cfg.roundInterval)
+		}
+
+		if cfg.walDir == "" {
+			return nil
+		}
+		return s.openWAL(ctx, cfg.walDir, cfg.wal, cfg.walCompactInterval)
+	})
 
 	// Handle the 'broadcast' message type
 	n.Handle("broadcast", func(msg maelstrom.Message) error {
-		var body map[string]any
+		var body struct {
+			Message json.RawMessage `json:"message"`
+		}
 
-		if err:=json.Unmarshal(msg.Body, &body); err != nil {
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
 			return err
 		}
 
-		msgFloat, ok := body["message"].(float64)
-		if !ok {
-			// Handle error: value is not an int
-			return errors.New("message field is not a float64")
+		if err := s.addValue(body.Message); err != nil {
+			return err
 		}
 
-		/*
-			Broadcast message to all neighbors (gossiping)
-
-			1.) Check if we've already seen this message. If we have, then simply reply, otherwise continue.
-			2.) Create broadcast message to send to neighboring nodes.
-			3.) Send all values to the neighboring node(s).
-		*/
-		key := strconv.FormatFloat(msgFloat, 'f', -1, 64)
-
-		if _, alreadySeen := seen.Load(key); !alreadySeen {
-			// Add the current value in 'message' to our map of already seen values 
-			seen.Store(key, true)
+		return n.Reply(msg, maelstrom.MessageBody{Type: "broadcast_ok"})
+	})
 
-			// Safely append the message value to the list of all messages
-			broadcastVals.Append(msgFloat)
+	// Handle the g-set workload's 'add' message type
+	n.Handle("add", func(msg maelstrom.Message) error {
+		var body struct {
+			Element json.RawMessage `json:"element"`
+		}
 
-			// Create a 'broadcast' message to send to all neighbors
-			neighborBody := copyStringMap(body)
-			neighborBody["type"] = "broadcast"
-			neighborBody["message"] = msgFloat
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
 
-			// Send message to all neighbors
-			broadcastMessageToAllNeighbors(neighbors, neighborBody, n)
+		if err := s.addValue(body.Element); err != nil {
+			return err
 		}
 
-		// Remove message field from response if it exists
-		delete(body, "message")
+		return n.Reply(msg, maelstrom.MessageBody{Type: "add_ok"})
+	})
 
-		body["type"] = "broadcast_ok"
+	// Handle the message types sent between nodes
+	n.Handle("gossip", s.handleGossip)
+	n.Handle("gossip_ok", s.handleGossipOK)
+	n.Handle("epidemic", s.handleEpidemic)
+	n.Handle("epidemic_ok", s.handleEpidemicOK)
+
+	// Handle the 'stats' message type, which reports how values have been delivered
+	n.Handle("stats", func(msg maelstrom.Message) error {
+		body := statsOKMessageBody{
+			Type:   "stats_ok",
+			Mode:   cfg.gossipMode,
+			Values: s.log.Version(),
+		}
+		primary := viaGossip
+		if s.rumors != nil {
+			body.HotRumors = s.rumors.Len()
+			primary = viaEpidemic
+		}
+		s.stats.snapshot(&body, primary)
 
 		return n.Reply(msg, body)
 	})
 
 	// Handle the 'read' message type
 	n.Handle("read", func(msg maelstrom.Message) error {
-		var body map[string]any
+		var body maelstrom.MessageBody
 
-		if err:=json.Unmarshal(msg.Body, &body); err != nil {
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
 			return err
 		}
 
-		body["type"] = "read_ok"
-		
-		// Create a copy of the messages safely
-		valsCopy := broadcastVals.GetCopy()
-
-		body["messages"] = valsCopy
-
-		return n.Reply(msg, body)
+		// Reply with Send instead of Reply, which would decode the values into float64s
+		// and lose the precision of large integers.
+		return n.Send(msg.Src, map[string]any{
+			"type":        "read_ok",
+			"in_reply_to": body.MsgID,
+			readKey:       s.log.Values(),
+		})
 	})
 
 	// Handle the 'topology' message type
 	n.Handle("topology", func(msg maelstrom.Message) error {
 		var body map[string]any
 
-		if err:=json.Unmarshal(msg.Body, &body); err != nil {
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
 			return err
 		}
 
@@ -169,10 +355,10 @@ func main() {
 		// The node only stores the neighbors corresponding to this specific node.
 		updatedNeighbors, err := extractCurrentNodesNeighbors(body, n.ID())
 		if err != nil {
-			log.Fatalf("Failed to extract neighbors for node %s: %v", n.ID(), err)
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
 		}
 
-		neighbors = updatedNeighbors
+		s.setNeighbors(updatedNeighbors)
 
 		// Remove "topology" key if it exists
 		delete(body, "topology")
@@ -182,8 +368,8 @@ func main() {
 		return n.Reply(msg, body)
 	})
 
-    // Start the Maelstrom node, which listens for incoming messages.
-    if err := n.Run(); err != nil {
-        log.Fatal(err)
-    }
+	// Periodically resend unacknowledged entries to every neighbor.
+	go s.runAntiEntropy(ctx, cfg.antiEntropyInterval)
+
+	return n, nil
 }
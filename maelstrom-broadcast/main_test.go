@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"maelstrom-broadcast/epidemic"
+	"maelstrom-broadcast/wal"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestMain(m *testing.M) {
+	// maelstrom.Node logs every message it sends and receives.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// clientID is the source ID of requests sent with testNetwork.call.
+const clientID = "c1"
+
+// testNetwork is an in-process Maelstrom network connecting broadcast nodes to each other and to
+// a test client. It counts the messages sent between nodes by type.
+type testNetwork struct {
+	t *testing.T
+
+	mu      sync.Mutex
+	inboxes map[string]chan []byte         // Lines waiting to be read by each node, by node ID.
+	ready   map[string]bool                // Nodes that have answered their "init".
+	pending map[int]chan maelstrom.Message // Client requests waiting for a reply, by msg_id.
+	counts  map[[3]string]int              // Messages sent, by source, destination and type.
+	dropped map[[3]string]bool             // Messages to drop, by source, destination and type.
+	msgID   int
+}
+
+// newTestNetwork returns an empty network whose nodes are closed when the test ends.
+func newTestNetwork(t *testing.T) *testNetwork {
+	net := &testNetwork{
+		t:       t,
+		inboxes: make(map[string]chan []byte),
+		ready:   make(map[string]bool),
+		pending: make(map[int]chan maelstrom.Message),
+		counts:  make(map[[3]string]int),
+		dropped: make(map[[3]string]bool),
+	}
+	t.Cleanup(func() {
+		net.mu.Lock()
+		defer net.mu.Unlock()
+		for id, inbox := range net.inboxes {
+			close(inbox)
+			delete(net.inboxes, id)
+		}
+	})
+	return net
+}
+
+// start runs a new node with cfg as id, replacing any node already running as id the way a
+// restart would: the old node stops receiving messages and the new one has none of its state.
+// The node is initialized with ids as the cluster.
+func (net *testNetwork) start(id string, ids []string, cfg config) {
+	net.t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n, err := newBroadcastNode(ctx, cfg)
+	if err != nil {
+		net.t.Fatal(err)
+	}
+	net.t.Cleanup(cancel)
+	inbox := make(chan []byte, 4096)
+	r, w := io.Pipe()
+	n.Stdin = r
+	n.Stdout = &lineWriter{net: net, inbox: inbox}
+
+	net.mu.Lock()
+	if old := net.inboxes[id]; old != nil {
+		close(old)
+	}
+	net.inboxes[id] = inbox
+	net.ready[id] = false
+	net.mu.Unlock()
+
+	go n.Run()
+	go func() {
+		defer w.Close()
+		for line := range inbox {
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+		}
+	}()
+
+	net.call(id, map[string]any{"type": "init", "node_id": id, "node_ids": ids})
+
+	net.mu.Lock()
+	net.ready[id] = true
+	net.mu.Unlock()
+}
+
+// call sends body to node id as a client request and returns the reply body, failing the test if
+// there is no reply or it is an error.
+func (net *testNetwork) call(id string, body map[string]any) map[string]any {
+	net.t.Helper()
+
+	ch := make(chan maelstrom.Message, 1)
+	net.mu.Lock()
+	net.msgID++
+	body["msg_id"] = net.msgID
+	net.pending[net.msgID] = ch
+	net.mu.Unlock()
+
+	buf, err := json.Marshal(body)
+	if err != nil {
+		net.t.Fatal(err)
+	}
+	net.route(maelstrom.Message{Src: clientID, Dest: id, Body: buf}, nil)
+
+	select {
+	case m := <-ch:
+		if err := m.RPCError(); err != nil {
+			net.t.Fatalf("%s to %s: %v", body["type"], id, err)
+		}
+		var reply map[string]any
+		if err := json.Unmarshal(m.Body, &reply); err != nil {
+			net.t.Fatal(err)
+		}
+		return reply
+	case <-time.After(5 * time.Second):
+		net.t.Fatalf("%s to %s: no reply", body["type"], id)
+		return nil
+	}
+}
+
+// read returns the values node id has seen, sorted.
+func (net *testNetwork) read(id string) []int {
+	net.t.Helper()
+
+	reply := net.call(id, map[string]any{"type": "read"})
+	var values []int
+	for _, v := range reply["messages"].([]any) {
+		values = append(values, int(v.(float64)))
+	}
+	sort.Ints(values)
+	return values
+}
+
+// waitForValues waits until node id has seen exactly want, failing the test after a few seconds.
+func (net *testNetwork) waitForValues(id string, want []int) {
+	net.t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := net.read(id)
+		if fmt.Sprint(got) == fmt.Sprint(want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			net.t.Fatalf("%s has %v, want %v", id, got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// sent returns how many messages of type typ src has sent to dest.
+func (net *testNetwork) sent(src, dest, typ string) int {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	return net.counts[[3]string{src, dest, typ}]
+}
+
+// drop drops every message of type typ from src to dest from now on.
+func (net *testNetwork) drop(src, dest, typ string) {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	net.dropped[[3]string{src, dest, typ}] = true
+}
+
+// route delivers m to its destination node, or to the client. If from is not nil, m was sent by
+// the node with that inbox, and is dropped if the node has been replaced or stopped.
+func (net *testNetwork) route(m maelstrom.Message, from chan []byte) {
+	var body maelstrom.MessageBody
+	if err := json.Unmarshal(m.Body, &body); err != nil {
+		return
+	}
+
+	net.mu.Lock()
+	defer net.mu.Unlock()
+
+	if from != nil && net.inboxes[m.Src] != from {
+		return
+	}
+
+	if m.Dest == clientID {
+		if ch := net.pending[body.InReplyTo]; ch != nil {
+			ch <- m
+			delete(net.pending, body.InReplyTo)
+		}
+		return
+	}
+
+	// Like Maelstrom, only deliver messages from other nodes once a node is initialized.
+	if m.Src != clientID {
+		net.counts[[3]string{m.Src, m.Dest, body.Type}]++
+		if !net.ready[m.Dest] || net.dropped[[3]string{m.Src, m.Dest, body.Type}] {
+			return
+		}
+	}
+	inbox := net.inboxes[m.Dest]
+	if inbox == nil {
+		return
+	}
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	inbox <- append(buf, '\n')
+}
+
+// lineWriter is a node's stdout: it routes each line the node writes as a message.
+type lineWriter struct {
+	net   *testNetwork
+	inbox chan []byte // The node's inbox, identifying it among the nodes that have run as id.
+	buf   bytes.Buffer
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadBytes('\n')
+		if err != nil {
+			// An incomplete line: put it back until the rest arrives.
+			w.buf.Write(line)
+			return len(p), nil
+		}
+
+		var m maelstrom.Message
+		if err := json.Unmarshal(line, &m); err != nil {
+			return 0, err
+		}
+		w.net.route(m, w.inbox)
+	}
+}
+
+// testConfig returns the configuration of a flooding broadcast node with fast anti-entropy.
+func testConfig() config {
+	return config{
+		workload:            "broadcast",
+		gossipMode:          "flood",
+		antiEntropyInterval: 10 * time.Millisecond,
+	}
+}
+
+// TestTopology_Change re-topologizes a running cluster, and checks that a new neighbor is caught
+// up on the values it missed and that a removed neighbor is no longer gossiped to.
+func TestTopology_Change(t *testing.T) {
+	net := newTestNetwork(t)
+	ids := []string{"n0", "n1", "n2"}
+	for _, id := range ids {
+		net.start(id, ids, testConfig())
+	}
+
+	// Start with n2 cut off from the others.
+	first := map[string]any{"n0": []string{"n1"}, "n1": []string{"n0"}, "n2": []string{}}
+	for _, id := range ids {
+		net.call(id, map[string]any{"type": "topology", "topology": first})
+	}
+	for v := 1; v <= 3; v++ {
+		net.call("n0", map[string]any{"type": "broadcast", "message": v})
+	}
+	net.waitForValues("n1", []int{1, 2, 3})
+	time.Sleep(50 * time.Millisecond)
+	if got := net.read("n2"); len(got) != 0 {
+		t.Fatalf("n2 has %v before joining the topology", got)
+	}
+
+	// Swap n1 out for n2.
+	next := map[string]any{"n0": []string{"n2"}, "n1": []string{}, "n2": []string{"n0"}}
+	for _, id := range ids {
+		net.call(id, map[string]any{"type": "topology", "topology": next})
+	}
+	net.waitForValues("n2", []int{1, 2, 3})
+
+	// Let gossip sent before the change drain, then check that n1 is left alone.
+	time.Sleep(50 * time.Millisecond)
+	before := net.sent("n0", "n1", "gossip")
+	net.call("n0", map[string]any{"type": "broadcast", "message": 4})
+	net.waitForValues("n2", []int{1, 2, 3, 4})
+	time.Sleep(50 * time.Millisecond)
+	if after := net.sent("n0", "n1", "gossip"); after != before {
+		t.Fatalf("n0 gossiped to removed neighbor n1 %d times", after-before)
+	}
+	if got := net.read("n1"); fmt.Sprint(got) != "[1 2 3]" {
+		t.Fatalf("n1 has %v, want [1 2 3]", got)
+	}
+}
+
+// TestRestart_Quiet restarts a node after traffic has stopped, and checks that its neighbor
+// notices and sends it every value again, including the ones the node itself gossiped before.
+func TestRestart_Quiet(t *testing.T) {
+	net := newTestNetwork(t)
+	ids := []string{"n0", "n1"}
+	for _, id := range ids {
+		net.start(id, ids, testConfig())
+	}
+
+	net.call("n0", map[string]any{"type": "broadcast", "message": 1})
+	net.call("n1", map[string]any{"type": "broadcast", "message": 2})
+	for _, id := range ids {
+		net.waitForValues(id, []int{1, 2})
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	net.start("n1", ids, testConfig())
+	net.waitForValues("n1", []int{1, 2})
+}
+
+// epidemicConfig returns the configuration of an epidemic broadcast node with fast rounds and
+// anti-entropy too slow to matter.
+func epidemicConfig() config {
+	return config{
+		workload:            "broadcast",
+		gossipMode:          "epidemic",
+		antiEntropyInterval: time.Hour,
+		epidemic:            epidemic.Config{Fanout: 1, MaxRounds: 20, StopAfter: 20},
+		roundInterval:       5 * time.Millisecond,
+	}
+}
+
+// TestNewBroadcastNode_Config checks that settings that would panic the background gossip are
+// rejected when the node is created.
+func TestNewBroadcastNode_Config(t *testing.T) {
+	withWAL := func(c *config) {
+		c.walDir = t.TempDir()
+		c.wal = wal.DefaultOptions
+		c.walCompactInterval = time.Minute
+	}
+
+	for name, change := range map[string]func(*config){
+		"negative fanout":                func(c *config) { c.epidemic.Fanout = -1 },
+		"zero fanout":                    func(c *config) { c.epidemic.Fanout = 0 },
+		"zero rounds":                    func(c *config) { c.epidemic.MaxRounds = 0 },
+		"zero stop-after":                func(c *config) { c.epidemic.StopAfter = 0 },
+		"zero round interval":            func(c *config) { c.roundInterval = 0 },
+		"negative anti-entropy interval": func(c *config) { c.antiEntropyInterval = -time.Second },
+		"zero wal sync interval":         func(c *config) { withWAL(c); c.wal.SyncInterval = 0 },
+		"negative wal max batch":         func(c *config) { withWAL(c); c.wal.MaxBatch = -1 },
+		"zero wal compact interval":      func(c *config) { withWAL(c); c.walCompactInterval = 0 },
+	} {
+		cfg := epidemicConfig()
+		change(&cfg)
+		if _, err := newBroadcastNode(context.Background(), cfg); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+
+	if _, err := newBroadcastNode(context.Background(), epidemicConfig()); err != nil {
+		t.Errorf("valid epidemic config: %v", err)
+	}
+	cfg := epidemicConfig()
+	withWAL(&cfg)
+	if _, err := newBroadcastNode(context.Background(), cfg); err != nil {
+		t.Errorf("valid config with a write-ahead log: %v", err)
+	}
+}
+
+// TestEpidemic_Pull stops a node's pushes from reaching its peer, and checks that the peer, which
+// has no rumors of its own, still pulls the values.
+func TestEpidemic_Pull(t *testing.T) {
+	net := newTestNetwork(t)
+	ids := []string{"n0", "n1"}
+	for _, id := range ids {
+		net.start(id, ids, epidemicConfig())
+	}
+	net.drop("n0", "n1", "epidemic")
+
+	net.call("n0", map[string]any{"type": "broadcast", "message": 1})
+	net.waitForValues("n1", []int{1})
+
+	// The value reached n1 as a pulled rumor, and is counted as an epidemic delivery.
+	stats := net.call("n1", map[string]any{"type": "stats"})
+	if stats["deliveries"].(float64) < 1 || stats["anti_entropy_deliveries"] != nil {
+		t.Fatalf("stats=%v, want only epidemic deliveries", stats)
+	}
+}
+
+// TestEpidemic_AntiEntropyStats checks that values delivered by the anti-entropy backstop are
+// counted apart from epidemic deliveries.
+func TestEpidemic_AntiEntropyStats(t *testing.T) {
+	cfg := epidemicConfig()
+	cfg.antiEntropyInterval = 5 * time.Millisecond
+
+	net := newTestNetwork(t)
+	ids := []string{"n0", "n1"}
+	for _, id := range ids {
+		net.start(id, ids, cfg)
+	}
+	net.drop("n0", "n1", "epidemic")
+	net.drop("n1", "n0", "epidemic")
+
+	net.call("n0", map[string]any{"type": "broadcast", "message": 1})
+	net.waitForValues("n1", []int{1})
+
+	stats := net.call("n1", map[string]any{"type": "stats"})
+	if stats["deliveries"] != float64(0) || stats["anti_entropy_deliveries"].(float64) < 1 {
+		t.Fatalf("stats=%v, want only anti-entropy deliveries", stats)
+	}
+}
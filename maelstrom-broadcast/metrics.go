@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sync"
+)
+
+// path is how a value was delivered to the node.
+type path int
+
+const (
+	viaGossip   path = iota // Delta gossip with the topology's neighbors: flooding, or anti-entropy.
+	viaEpidemic             // An epidemic round's push or pull.
+)
+
+// deliveryCounts counts the deliveries made along one path.
+type deliveryCounts struct {
+	deliveries int // Values received from other nodes, including duplicates.
+	redundant  int // Deliveries of values that were already known.
+}
+
+// deliveryStats counts how values arrive from other nodes, so that the gossip modes can be compared.
+// A delivery is redundant when it carries a value the node already had.
+type deliveryStats struct {
+	mu     sync.Mutex        // mu guards every field below.
+	byPath [2]deliveryCounts // Deliveries, indexed by path.
+	aged   int               // New values that arrived with a known age.
+	ageSum int               // Sum of the ages of those values.
+	ageMax int               // Largest age of those values.
+}
+
+// record counts one delivery of a value along p; isNew is false if the value was already known.
+func (d *deliveryStats) record(p path, isNew bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.byPath[p].deliveries++
+	if !isNew {
+		d.byPath[p].redundant++
+	}
+}
+
+// recordAge records the age, in gossip rounds since it was first broadcast, of a value
+// at the moment this node first received it.
+func (d *deliveryStats) recordAge(age int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.aged++
+	d.ageSum += age
+	if age > d.ageMax {
+		d.ageMax = age
+	}
+}
+
+// statsOKMessageBody is the reply to a "stats" message.
+//
+// Deliveries, Redundant and RedundantRatio count the deliveries made by the mode's own way of
+// spreading values: flooding gossip, or epidemic rounds. RedundantRatio is the fraction of them
+// that carried a value the node already had. In epidemic mode, the deliveries made by the
+// anti-entropy backstop are counted separately, so they don't skew the ratio.
+// RoundsToReceiveMax and RoundsToReceiveMean describe how many epidemic rounds values took to
+// reach this node; the maximum across all nodes is the cluster's rounds-to-convergence.
+type statsOKMessageBody struct {
+	Type                  string  `json:"type"`
+	Mode                  string  `json:"mode"`
+	Values                int     `json:"values"`
+	HotRumors             int     `json:"hot_rumors"`
+	Deliveries            int     `json:"deliveries"`
+	Redundant             int     `json:"redundant"`
+	RedundantRatio        float64 `json:"redundant_ratio"`
+	AntiEntropyDeliveries int     `json:"anti_entropy_deliveries,omitempty"`
+	AntiEntropyRedundant  int     `json:"anti_entropy_redundant,omitempty"`
+	RoundsToReceiveMax    int     `json:"rounds_to_receive_max"`
+	RoundsToReceiveMean   float64 `json:"rounds_to_receive_mean"`
+}
+
+// snapshot fills in the delivery fields of a "stats_ok" body for a node whose values are spread
+// along primary.
+func (d *deliveryStats) snapshot(body *statsOKMessageBody, primary path) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	own := d.byPath[primary]
+	body.Deliveries = own.deliveries
+	body.Redundant = own.redundant
+	if own.deliveries > 0 {
+		body.RedundantRatio = float64(own.redundant) / float64(own.deliveries)
+	}
+	if primary != viaGossip {
+		body.AntiEntropyDeliveries = d.byPath[viaGossip].deliveries
+		body.AntiEntropyRedundant = d.byPath[viaGossip].redundant
+	}
+
+	body.RoundsToReceiveMax = d.ageMax
+	if d.aged > 0 {
+		body.RoundsToReceiveMean = float64(d.ageSum) / float64(d.aged)
+	}
+}
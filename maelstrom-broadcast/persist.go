@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"path/filepath"
+	"time"
+
+	"maelstrom-broadcast/seenlog"
+	"maelstrom-broadcast/wal"
+)
+
+/*
+openWAL opens this node's write-ahead log and replays it into the in-memory log.
+It is called from the "init" handler, so recovery finishes before the node replies with "init_ok".
+
+Parameters:
+  - ctx: compaction stops once ctx is done.
+  - dir: the base WAL directory; each node keeps its files in a subdirectory named after its ID.
+  - opts: the WAL sync options.
+  - compactInterval: how often the WAL is compacted into a snapshot.
+
+Returns:
+  - An error if the WAL can't be opened or holds a record that isn't a valid value.
+*/
+func (s *server) openWAL(ctx context.Context, dir string, opts wal.Options, compactInterval time.Duration) error {
+	w, records, err := wal.Open(filepath.Join(dir, s.n.ID()), opts)
+	if err != nil {
+		return err
+	}
+
+	// Records are the values' JSON encodings, exactly as they were received.
+	for _, rec := range records {
+		if _, err := s.log.Add(rec, ""); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	log.Printf("Recovered %d values from WAL", s.log.Version())
+
+	s.wal.Store(w)
+	go s.runCompaction(ctx, w, compactInterval)
+
+	return nil
+}
+
+/*
+addDurably persists values and then adds them to the in-memory log, so that a value is never in
+the log, and so never acknowledged as a duplicate, before it is durable.
+
+Parameters:
+  - origin: the node the values were gossiped by, or "" for values from a client.
+  - keys: the values' keys, as returned by seenlog.KeyOf.
+  - values: the values.
+
+Returns:
+  - Whether each value was new to the log.
+  - An error if the values couldn't be persisted, in which case none of them are added.
+
+Values already in the log aren't persisted again. A value added concurrently by another caller
+may be persisted twice, which is harmless since the log is deduplicated on replay.
+*/
+func (s *server) addDurably(origin string, keys []seenlog.Key, values []json.RawMessage) ([]bool, error) {
+	var fresh []json.RawMessage
+	for i, k := range keys {
+		if !s.log.Has(k) {
+			fresh = append(fresh, values[i])
+		}
+	}
+
+	// Compaction snapshots the log, so it must not run between the append and the add.
+	s.compactMu.RLock()
+	defer s.compactMu.RUnlock()
+
+	if err := s.persist(fresh...); err != nil {
+		return nil, err
+	}
+
+	added := make([]bool, len(keys))
+	for i, k := range keys {
+		added[i] = s.log.AddWithKey(k, values[i], origin)
+	}
+	return added, nil
+}
+
+// persist durably records values in the WAL, blocking until they are fsynced.
+// It is a no-op when the node is running without a WAL.
+func (s *server) persist(values ...json.RawMessage) error {
+	w := s.wal.Load()
+	if w == nil || len(values) == 0 {
+		return nil
+	}
+	return w.Append(toRecords(values)...)
+}
+
+// toRecords converts values to WAL records without copying them.
+func toRecords(values []json.RawMessage) [][]byte {
+	records := make([][]byte, len(values))
+	for i, v := range values {
+		records[i] = v
+	}
+	return records
+}
+
+// runCompaction periodically replaces the contents of w with a snapshot of the in-memory log.
+// It returns once ctx is done.
+func (s *server) runCompaction(ctx context.Context, w *wal.WAL, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if w.Size() == 0 {
+			continue
+		}
+
+		// Values are appended to the WAL and then added to the in-memory log under a read lock,
+		// so with the write lock held a snapshot of the log covers everything in the WAL.
+		s.compactMu.Lock()
+		err := w.Compact(func() [][]byte {
+			return toRecords(s.log.Values())
+		})
+		s.compactMu.Unlock()
+		if err != nil {
+			log.Printf("Error compacting WAL: %v", err)
+		}
+	}
+}
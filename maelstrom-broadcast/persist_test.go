@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"maelstrom-broadcast/wal"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// TestAddValue_PersistFails checks that a value whose append fails isn't recorded, so the client's
+// retry appends it again instead of being acknowledged as a duplicate.
+func TestAddValue_PersistFails(t *testing.T) {
+	dir := t.TempDir()
+	s := newServer(maelstrom.NewNode())
+
+	w, _, err := wal.Open(dir, wal.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.wal.Store(w)
+	w.Close()
+
+	value := json.RawMessage(`42`)
+	if err := s.addValue(value); err == nil {
+		t.Fatal("value acknowledged with a closed WAL")
+	}
+	if got := s.log.Version(); got != 0 {
+		t.Fatalf("log has %d values after a failed append, want 0", got)
+	}
+
+	w, _, err = wal.Open(dir, wal.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.wal.Store(w)
+	if err := s.addValue(value); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	w, records, err := wal.Open(dir, wal.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if len(records) != 1 || string(records[0]) != "42" {
+		t.Fatalf("WAL holds %q, want [42]", records)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"time"
+
+	"maelstrom-broadcast/epidemic"
+	"maelstrom-broadcast/seenlog"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// epidemicMessageBody is the body of an "epidemic" message: the sender's hot rumors.
+// Like gossip, epidemic messages are exchanged with Send so large integers keep their precision.
+type epidemicMessageBody struct {
+	maelstrom.MessageBody
+	Messages []epidemic.Rumor `json:"messages"`
+}
+
+// epidemicOKMessageBody answers an "epidemic" message. Messages holds the receiver's own hot
+// rumors (the pull half of the exchange) and Redundant echoes the pushed values it already had,
+// so the sender can retire them.
+type epidemicOKMessageBody struct {
+	maelstrom.MessageBody
+	Messages  []epidemic.Rumor  `json:"messages"`
+	Redundant []json.RawMessage `json:"redundant"`
+}
+
+// runEpidemic runs a round of push-pull gossip with cfg.Fanout random peers every interval.
+// Peers are drawn from the whole cluster regardless of the topology. It returns once ctx is done.
+//
+// A node with no hot rumors still contacts its peers, pushing nothing, so that it pulls theirs.
+func (s *server) runEpidemic(ctx context.Context, cfg epidemic.Config, interval time.Duration) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		body := epidemicMessageBody{
+			MessageBody: maelstrom.MessageBody{Type: "epidemic"},
+			Messages:    s.rumors.Round(),
+		}
+		for _, peer := range epidemic.PickPeers(rng, s.n.ID(), s.n.NodeIDs(), cfg.Fanout) {
+			if err := s.n.Send(peer, body); err != nil {
+				log.Printf("Error sending epidemic round to %s: %v", peer, err)
+			}
+		}
+	}
+}
+
+/*
+mergeRumors records the values in rumors received from src.
+
+Parameters:
+  - src: the node that sent the rumors.
+  - rumors: the received rumors.
+
+Returns:
+  - The keys of every received rumor.
+  - The values that were already known.
+
+New values are persisted and start being spread by this node as well, keeping their age.
+*/
+func (s *server) mergeRumors(src string, rumors []epidemic.Rumor) (map[seenlog.Key]struct{}, []json.RawMessage) {
+	keys := make(map[seenlog.Key]struct{}, len(rumors))
+	var (
+		valid     []epidemic.Rumor
+		validKeys []seenlog.Key
+		values    []json.RawMessage
+		redundant []json.RawMessage
+	)
+	for _, r := range rumors {
+		k, err := seenlog.KeyOf(r.Value)
+		if err != nil {
+			log.Printf("Malformed rumor from %s: %v", src, err)
+			continue
+		}
+		keys[k] = struct{}{}
+		valid = append(valid, r)
+		validKeys = append(validKeys, k)
+		values = append(values, r.Value)
+	}
+
+	// Rumors that couldn't be persisted are dropped; they are still hot on src, or arrive
+	// through anti-entropy.
+	added, err := s.addDurably(src, validKeys, values)
+	if err != nil {
+		log.Printf("Error persisting rumors from %s: %v", src, err)
+		return keys, nil
+	}
+
+	for i, r := range valid {
+		s.stats.record(viaEpidemic, added[i])
+		if !added[i] {
+			redundant = append(redundant, r.Value)
+			continue
+		}
+
+		s.stats.recordAge(r.Age)
+		s.rumors.Add(validKeys[i], r.Value, r.Age)
+	}
+
+	return keys, redundant
+}
+
+// handleEpidemic merges pushed rumors and answers with our own hot rumors and the pushed values
+// we already had. Like handleGossip, it logs errors instead of returning them.
+func (s *server) handleEpidemic(msg maelstrom.Message) error {
+	var body epidemicMessageBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		log.Printf("Malformed epidemic message from %s: %v", msg.Src, err)
+		return nil
+	}
+
+	pushed, redundant := s.mergeRumors(msg.Src, body.Messages)
+
+	// Don't send back what the peer just pushed to us.
+	var pulled []epidemic.Rumor
+	for _, r := range s.rumors.Hot() {
+		if k, err := seenlog.KeyOf(r.Value); err == nil {
+			if _, ok := pushed[k]; ok {
+				continue
+			}
+		}
+		pulled = append(pulled, r)
+	}
+
+	err := s.n.Send(msg.Src, epidemicOKMessageBody{
+		MessageBody: maelstrom.MessageBody{Type: "epidemic_ok"},
+		Messages:    pulled,
+		Redundant:   redundant,
+	})
+	if err != nil {
+		log.Printf("Error answering epidemic message from %s: %v", msg.Src, err)
+	}
+	return nil
+}
+
+// handleEpidemicOK merges pulled rumors and retires pushed rumors the peer already had.
+func (s *server) handleEpidemicOK(msg maelstrom.Message) error {
+	var body epidemicOKMessageBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		log.Printf("Malformed epidemic_ok from %s: %v", msg.Src, err)
+		return nil
+	}
+
+	s.mergeRumors(msg.Src, body.Messages)
+
+	for _, v := range body.Redundant {
+		if k, err := seenlog.KeyOf(v); err == nil {
+			s.rumors.Redundant(k)
+		}
+	}
+	return nil
+}
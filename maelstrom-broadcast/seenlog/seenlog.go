@@ -0,0 +1,136 @@
+package seenlog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+)
+
+// Entry is a single value recorded in the log along with the node it was learned from.
+// Origin is empty for values that were broadcast to this node directly by a client.
+type Entry struct {
+	Value  json.RawMessage // The value exactly as it was first received.
+	Origin string          // The node that gossiped the value to us, if any.
+}
+
+// Key identifies a value independently of how it was encoded: it is the SHA-256 hash of
+// the value's canonical JSON encoding.
+type Key [sha256.Size]byte
+
+/*
+KeyOf returns the deduplication key for a JSON value.
+
+Parameters:
+  - value: any valid JSON value.
+
+Returns:
+  - The hash of the value's canonical encoding. Two encodings of the same value, e.g. with
+    different whitespace, object key order or string escapes, have the same key.
+    Numbers are compared by their literal text so that large integers never lose precision.
+  - An error if value is not valid JSON.
+*/
+func KeyOf(value json.RawMessage) (Key, error) {
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return Key{}, err
+	}
+
+	// Marshaling decoded values sorts object keys and drops insignificant whitespace.
+	canonical, err := json.Marshal(v)
+	if err != nil {
+		return Key{}, err
+	}
+	return sha256.Sum256(canonical), nil
+}
+
+// Log is a thread-safe, append-only log of every value this node has seen.
+// Each value appears at most once. The position of an entry in the log never changes,
+// so a log offset (version) can be used as a high-water mark by gossip peers.
+type Log struct {
+	mu      sync.RWMutex     // mu guards access to entries and seen.
+	entries []Entry          // The entries in the order they were first seen.
+	seen    map[Key]struct{} // Keys of all values in entries, for O(1) duplicate checks.
+}
+
+// NewLog initializes and returns a pointer to a new, empty Log.
+func NewLog() *Log {
+	return &Log{
+		entries: make([]Entry, 0),
+		seen:    make(map[Key]struct{}),
+	}
+}
+
+// Add appends value to the log if it has not been seen before.
+// It returns true if the value was new, false if it was already present, and an error if
+// value is not valid JSON.
+func (l *Log) Add(value json.RawMessage, origin string) (bool, error) {
+	k, err := KeyOf(value)
+	if err != nil {
+		return false, err
+	}
+	return l.AddWithKey(k, value, origin), nil
+}
+
+// AddWithKey is like Add for callers that have already computed the value's key with KeyOf.
+// The duplicate check and the append happen under the same lock, so concurrent callers adding
+// the same value will only ever record it once.
+func (l *Log) AddWithKey(k Key, value json.RawMessage, origin string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.seen[k]; ok {
+		return false
+	}
+	l.seen[k] = struct{}{}
+	l.entries = append(l.entries, Entry{Value: value, Origin: origin})
+	return true
+}
+
+// Has reports whether the value with key k is in the log.
+func (l *Log) Has(k Key) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	_, ok := l.seen[k]
+	return ok
+}
+
+// Since returns a copy of all entries after the given version, along with the current version.
+// A version is simply the number of entries in the log, so Since(0) returns everything.
+// Versions greater than the current length are treated as 0, since they can only come from
+// a peer that is tracking a log we no longer have.
+func (l *Log) Since(version int) ([]Entry, int) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if version < 0 || version > len(l.entries) {
+		version = 0
+	}
+
+	delta := make([]Entry, len(l.entries)-version)
+	copy(delta, l.entries[version:])
+	return delta, len(l.entries)
+}
+
+// Values returns a copy of every value in the log, in the order they were first seen.
+func (l *Log) Values() []json.RawMessage {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	values := make([]json.RawMessage, len(l.entries))
+	for i, e := range l.entries {
+		values[i] = e.Value
+	}
+	return values
+}
+
+// Version returns the current version of the log, i.e. the number of entries in it.
+func (l *Log) Version() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.entries)
+}
@@ -0,0 +1,63 @@
+package seenlog_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"maelstrom-broadcast/seenlog"
+)
+
+func TestKeyOf(t *testing.T) {
+	for _, tt := range []struct {
+		a, b  string
+		equal bool
+	}{
+		{`{"a":1,"b":[1,2]}`, `{ "b": [1, 2], "a": 1 }`, true},
+		{`"A"`, `"A"`, true},
+		{`12345678901234567890123`, `12345678901234567890124`, false},
+		{`1`, `"1"`, false},
+		{`null`, `null`, true},
+	} {
+		ka, err := seenlog.KeyOf(json.RawMessage(tt.a))
+		if err != nil {
+			t.Fatal(err)
+		}
+		kb, err := seenlog.KeyOf(json.RawMessage(tt.b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (ka == kb) != tt.equal {
+			t.Fatalf("KeyOf(%s) == KeyOf(%s) is %v, want %v", tt.a, tt.b, ka == kb, tt.equal)
+		}
+	}
+
+	if _, err := seenlog.KeyOf(json.RawMessage(`{"a":`)); err == nil {
+		t.Fatal("expected error for invalid JSON")
+	}
+}
+
+// Ensure values are deduplicated by content and returned exactly as first received.
+func TestLog_Add(t *testing.T) {
+	l := seenlog.NewLog()
+
+	for _, v := range []string{`12345678901234567890123`, `{"a":1}`, `{ "a": 1 }`, `12345678901234567890123`} {
+		if _, err := l.Add(json.RawMessage(v), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	values := l.Values()
+	if len(values) != 2 {
+		t.Fatalf("len=%d, want 2", len(values))
+	}
+	if got, want := string(values[0]), `12345678901234567890123`; got != want {
+		t.Fatalf("values[0]=%s, want %s", got, want)
+	}
+	if got, want := string(values[1]), `{"a":1}`; got != want {
+		t.Fatalf("values[1]=%s, want %s", got, want)
+	}
+
+	if entries, version := l.Since(1); version != 2 || len(entries) != 1 {
+		t.Fatalf("Since(1)=%d entries at version %d, want 1 at version 2", len(entries), version)
+	}
+}
@@ -0,0 +1,82 @@
+package topology
+
+import (
+	"sync"
+)
+
+// Topology holds a node's current set of neighbors and is safe for concurrent use.
+// It can be replaced at any time while other goroutines are reading it.
+type Topology struct {
+	mu        sync.RWMutex        // mu guards access to neighbors and set.
+	neighbors []string            // Neighbor IDs in the order they were given.
+	set       map[string]struct{} // The same IDs, for O(1) membership checks.
+}
+
+// New initializes and returns a pointer to a new Topology with no neighbors.
+func New() *Topology {
+	return &Topology{
+		neighbors: make([]string, 0),
+		set:       make(map[string]struct{}),
+	}
+}
+
+// Neighbors returns a copy of the current neighbors.
+func (t *Topology) Neighbors() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	neighbors := make([]string, len(t.neighbors))
+	copy(neighbors, t.neighbors)
+	return neighbors
+}
+
+// Contains reports whether id is currently a neighbor.
+func (t *Topology) Contains(id string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	_, ok := t.set[id]
+	return ok
+}
+
+/*
+Set replaces the current neighbors. Duplicate IDs and the node's own ID are dropped.
+
+Parameters:
+  - self: the ID of the local node.
+  - neighbors: the new list of neighbor IDs.
+
+Returns:
+  - The neighbors that were not in the previous topology.
+  - The neighbors that were in the previous topology but are not in the new one.
+*/
+func (t *Topology) Set(self string, neighbors []string) (added, removed []string) {
+	next := make([]string, 0, len(neighbors))
+	set := make(map[string]struct{}, len(neighbors))
+	for _, id := range neighbors {
+		if _, ok := set[id]; ok || id == self {
+			continue
+		}
+		set[id] = struct{}{}
+		next = append(next, id)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, id := range next {
+		if _, ok := t.set[id]; !ok {
+			added = append(added, id)
+		}
+	}
+	for _, id := range t.neighbors {
+		if _, ok := set[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+
+	t.neighbors = next
+	t.set = set
+
+	return added, removed
+}
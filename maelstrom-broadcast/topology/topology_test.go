@@ -0,0 +1,61 @@
+package topology_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"maelstrom-broadcast/topology"
+)
+
+func TestTopology_Set(t *testing.T) {
+	top := topology.New()
+
+	added, removed := top.Set("n1", []string{"n2", "n3", "n2", "n1"})
+	if want := []string{"n2", "n3"}; !reflect.DeepEqual(added, want) {
+		t.Fatalf("added=%q, want %q", added, want)
+	}
+	if len(removed) != 0 {
+		t.Fatalf("removed=%q, want none", removed)
+	}
+	if got, want := top.Neighbors(), []string{"n2", "n3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("neighbors=%q, want %q", got, want)
+	}
+
+	added, removed = top.Set("n1", []string{"n3", "n4"})
+	if want := []string{"n4"}; !reflect.DeepEqual(added, want) {
+		t.Fatalf("added=%q, want %q", added, want)
+	}
+	if want := []string{"n2"}; !reflect.DeepEqual(removed, want) {
+		t.Fatalf("removed=%q, want %q", removed, want)
+	}
+	if top.Contains("n2") || !top.Contains("n4") {
+		t.Fatalf("unexpected membership after re-topology: %q", top.Neighbors())
+	}
+}
+
+// Ensure readers and writers can use a topology concurrently; run with -race.
+func TestTopology_Concurrent(t *testing.T) {
+	top := topology.New()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				top.Set("n1", []string{"n2", "n3"})
+				top.Set("n1", []string{"n4"})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				for _, id := range top.Neighbors() {
+					top.Contains(id)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,409 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// File names used inside a WAL directory.
+const (
+	logFileName      = "wal.log"
+	snapshotFileName = "snapshot.dat"
+	snapshotTmpName  = "snapshot.dat.tmp"
+)
+
+// headerSize is the size of a record header: a 4-byte payload length followed by a 4-byte CRC-32C.
+const headerSize = 8
+
+// maxRecordSize bounds the length read from a record header, so a corrupted length
+// can't make recovery allocate an absurd amount of memory.
+const maxRecordSize = 16 << 20
+
+// crcTable is the Castagnoli table used to checksum record payloads.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrClosed is returned when appending to or compacting a closed WAL.
+var ErrClosed = errors.New("wal: closed")
+
+// Options configures how often a WAL is synced to disk.
+type Options struct {
+	// SyncInterval is the longest an appended record waits before it is fsynced.
+	// Records appended within the same interval share a single fsync.
+	SyncInterval time.Duration
+
+	// MaxBatch forces an early fsync once this many appends are waiting.
+	MaxBatch int
+}
+
+// DefaultOptions are the options used when none are given.
+var DefaultOptions = Options{
+	SyncInterval: 5 * time.Millisecond,
+	MaxBatch:     128,
+}
+
+// WAL is an append-only, checksummed write-ahead log of opaque records stored in a directory.
+//
+// Appends are made durable in batches: every Append blocks until an fsync covering its records
+// has completed. Compact replaces everything written so far with a snapshot, so the log file
+// doesn't grow without bound. Records may be replayed more than once after a crash during
+// compaction, so whatever is stored in the WAL must be safe to apply twice.
+type WAL struct {
+	dir  string
+	opts Options
+
+	mu      sync.Mutex    // mu guards every field below.
+	f       *os.File      // The open log file.
+	w       *bufio.Writer // Buffered writer on f; flushed before every fsync.
+	size    int64         // Bytes written to the log file, including buffered ones.
+	waiters []chan error  // Appends waiting for the next fsync.
+	closed  bool
+
+	kick chan struct{} // Wakes the syncer early when MaxBatch is reached.
+	done chan struct{} // Closed when the syncer exits.
+}
+
+/*
+Open opens the WAL stored in dir, creating the directory if needed, and recovers its contents.
+
+Parameters:
+  - dir: the directory holding the snapshot and log files.
+  - opts: sync options; zero values are replaced with those from DefaultOptions.
+
+Returns:
+  - The opened WAL, ready for appends.
+  - Every record in the snapshot followed by every intact record in the log, in order.
+  - An error if the files can't be read or the snapshot is corrupt.
+
+A torn or corrupt tail in the log file, as left by a crash in the middle of a write,
+is not an error: recovery stops at the last intact record and the tail is truncated away.
+*/
+func Open(dir string, opts Options) (*WAL, [][]byte, error) {
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultOptions.SyncInterval
+	}
+	if opts.MaxBatch <= 0 {
+		opts.MaxBatch = DefaultOptions.MaxBatch
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+
+	// A leftover temporary snapshot means we crashed while compacting; the old snapshot
+	// and log are still complete, so it can simply be discarded.
+	if err := os.Remove(filepath.Join(dir, snapshotTmpName)); err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	records, err := readSnapshot(filepath.Join(dir, snapshotFileName))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, logFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logRecords, valid, err := readRecords(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	records = append(records, logRecords...)
+
+	// Drop any torn tail so new records are appended right after the last intact one.
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	w := &WAL{
+		dir:  dir,
+		opts: opts,
+		f:    f,
+		w:    bufio.NewWriter(f),
+		size: valid,
+		kick: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	go w.runSyncer()
+
+	return w, records, nil
+}
+
+// readSnapshot returns the records in the snapshot file at path, or none if it doesn't exist.
+// Snapshots are only ever installed by an atomic rename, so any damage is reported as an error.
+func readSnapshot(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	records, valid, err := readRecords(f)
+	if err != nil {
+		return nil, err
+	} else if valid != info.Size() {
+		return nil, fmt.Errorf("wal: corrupt snapshot %s at offset %d", path, valid)
+	}
+	return records, nil
+}
+
+// readRecords reads records from r until the end of the input or the first torn or corrupt record.
+// It returns the intact records and the number of bytes they occupy.
+func readRecords(r io.Reader) ([][]byte, int64, error) {
+	br := bufio.NewReader(r)
+
+	var (
+		records [][]byte
+		valid   int64
+		header  [headerSize]byte
+	)
+	for {
+		if _, err := io.ReadFull(br, header[:]); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return records, valid, nil
+		} else if err != nil {
+			return nil, 0, err
+		}
+
+		length := binary.LittleEndian.Uint32(header[0:4])
+		checksum := binary.LittleEndian.Uint32(header[4:8])
+		if length > maxRecordSize {
+			return records, valid, nil
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(br, payload); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return records, valid, nil
+		} else if err != nil {
+			return nil, 0, err
+		}
+
+		if crc32.Checksum(payload, crcTable) != checksum {
+			return records, valid, nil
+		}
+
+		records = append(records, payload)
+		valid += headerSize + int64(length)
+	}
+}
+
+// writeRecord writes a single framed record to w and returns the number of bytes written.
+func writeRecord(w io.Writer, payload []byte) (int64, error) {
+	var header [headerSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:8], crc32.Checksum(payload, crcTable))
+
+	if _, err := w.Write(header[:]); err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return 0, err
+	}
+	return headerSize + int64(len(payload)), nil
+}
+
+// Append writes records to the log and blocks until they have been fsynced.
+func (w *WAL) Append(records ...[]byte) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	ch := make(chan error, 1)
+
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrClosed
+	}
+	for _, rec := range records {
+		if len(rec) > maxRecordSize {
+			w.mu.Unlock()
+			return fmt.Errorf("wal: record of %d bytes exceeds limit", len(rec))
+		}
+		n, err := writeRecord(w.w, rec)
+		w.size += n
+		if err != nil {
+			w.mu.Unlock()
+			return err
+		}
+	}
+	w.waiters = append(w.waiters, ch)
+	full := len(w.waiters) >= w.opts.MaxBatch
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+
+	return <-ch
+}
+
+// Size returns the current size of the log file in bytes, including records not yet synced.
+func (w *WAL) Size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size
+}
+
+// runSyncer fsyncs pending appends every SyncInterval, or sooner when kicked, until the WAL is closed.
+func (w *WAL) runSyncer() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.kick:
+		}
+
+		w.mu.Lock()
+		if w.closed {
+			w.mu.Unlock()
+			return
+		}
+		w.syncLocked()
+		w.mu.Unlock()
+	}
+}
+
+// syncLocked flushes and fsyncs the log file, then releases every waiting append with the result.
+// The caller must hold w.mu.
+func (w *WAL) syncLocked() {
+	if len(w.waiters) == 0 {
+		return
+	}
+
+	err := w.w.Flush()
+	if err == nil {
+		err = w.f.Sync()
+	}
+
+	for _, ch := range w.waiters {
+		ch <- err
+	}
+	w.waiters = nil
+}
+
+/*
+Compact replaces the snapshot and log with a new snapshot holding the given records.
+
+Parameters:
+  - snapshot: called with appends blocked; it must return records covering everything
+    appended so far, since the log is emptied once the new snapshot is in place.
+
+The new snapshot is written to a temporary file, fsynced and renamed into place before
+the log is truncated. A crash at any point leaves either the old snapshot and log or the
+new snapshot (plus, possibly, the old log whose records it already covers).
+*/
+func (w *WAL) Compact(snapshot func() [][]byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	// Make pending appends durable first; their callers are released as usual.
+	w.syncLocked()
+
+	tmpPath := filepath.Join(w.dir, snapshotTmpName)
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(tmp)
+	for _, rec := range snapshot() {
+		if _, err := writeRecord(bw, rec); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, filepath.Join(w.dir, snapshotFileName)); err != nil {
+		return err
+	}
+	if err := syncDir(w.dir); err != nil {
+		return err
+	}
+
+	// Everything in the log is now covered by the snapshot.
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w.w.Reset(w.f)
+	w.size = 0
+
+	return w.f.Sync()
+}
+
+// syncDir fsyncs a directory so that a rename inside it is durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Close syncs any pending appends and closes the log file.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrClosed
+	}
+	w.syncLocked()
+	w.closed = true
+	w.mu.Unlock()
+
+	// Wake the syncer so it notices the WAL is closed.
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+	<-w.done
+
+	return w.f.Close()
+}
@@ -0,0 +1,229 @@
+package wal_test
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"maelstrom-broadcast/wal"
+)
+
+// openWAL opens a WAL in dir and fails the test on error.
+func openWAL(t *testing.T, dir string) (*wal.WAL, [][]byte) {
+	t.Helper()
+	w, records, err := wal.Open(dir, wal.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, records
+}
+
+// makeRecords returns n distinct records of varying length.
+func makeRecords(n int) [][]byte {
+	records := make([][]byte, n)
+	for i := range records {
+		records[i] = []byte(fmt.Sprintf("record-%d-%s", i, make([]byte, i%17)))
+	}
+	return records
+}
+
+func TestWAL_AppendAndRecover(t *testing.T) {
+	dir := t.TempDir()
+	want := makeRecords(100)
+
+	w, records := openWAL(t, dir)
+	if len(records) != 0 {
+		t.Fatalf("recovered %d records from empty dir", len(records))
+	}
+
+	// Append concurrently so that appends share fsyncs.
+	var wg sync.WaitGroup
+	for _, rec := range want {
+		wg.Add(1)
+		go func(rec []byte) {
+			defer wg.Done()
+			if err := w.Append(rec); err != nil {
+				t.Error(err)
+			}
+		}(rec)
+	}
+	wg.Wait()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, got := openWAL(t, dir)
+	if len(got) != len(want) {
+		t.Fatalf("recovered %d records, want %d", len(got), len(want))
+	}
+
+	seen := make(map[string]bool)
+	for _, rec := range got {
+		seen[string(rec)] = true
+	}
+	for _, rec := range want {
+		if !seen[string(rec)] {
+			t.Fatalf("record %q not recovered", rec)
+		}
+	}
+}
+
+func TestWAL_Compact(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := openWAL(t, dir)
+
+	want := makeRecords(10)
+	if err := w.Append(want[:5]...); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Compact(func() [][]byte { return want[:5] }); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Size(); got != 0 {
+		t.Fatalf("log size after compaction=%d, want 0", got)
+	}
+	if err := w.Append(want[5:]...); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, got := openWAL(t, dir); !reflect.DeepEqual(got, want) {
+		t.Fatalf("recovered %q, want %q", got, want)
+	}
+}
+
+// Ensure a log cut off at any offset, as by a crash in the middle of a write, recovers
+// exactly the records that were completely written and can be appended to afterwards.
+func TestWAL_CrashTruncation(t *testing.T) {
+	src := t.TempDir()
+	want := makeRecords(50)
+
+	w, _ := openWAL(t, src)
+	if err := w.Append(want[:10]...); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Compact(func() [][]byte { return want[:10] }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Remember where each record in the log ends.
+	var ends []int64
+	for _, rec := range want[10:] {
+		if err := w.Append(rec); err != nil {
+			t.Fatal(err)
+		}
+		ends = append(ends, w.Size())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := os.ReadFile(filepath.Join(src, "snapshot.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logData, err := os.ReadFile(filepath.Join(src, "wal.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		offset := rng.Int63n(int64(len(logData)) + 1)
+
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "snapshot.dat"), snapshot, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "wal.log"), logData[:offset], 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		complete := 0
+		for complete < len(ends) && ends[complete] <= offset {
+			complete++
+		}
+
+		w, got := openWAL(t, dir)
+		if !reflect.DeepEqual(got, want[:10+complete]) {
+			t.Fatalf("offset %d: recovered %d records, want %d", offset, len(got), 10+complete)
+		}
+
+		// Appending after recovery must not leave the torn tail in front of the new record.
+		extra := []byte("after-crash")
+		if err := w.Append(extra); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		_, got = openWAL(t, dir)
+		if len(got) != 10+complete+1 || string(got[len(got)-1]) != string(extra) {
+			t.Fatalf("offset %d: record appended after recovery was lost", offset)
+		}
+	}
+}
+
+// Ensure a flipped bit in the log stops recovery at the damaged record.
+func TestWAL_CorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	want := makeRecords(5)
+
+	w, _ := openWAL(t, dir)
+	var ends []int64
+	for _, rec := range want {
+		if err := w.Append(rec); err != nil {
+			t.Fatal(err)
+		}
+		ends = append(ends, w.Size())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "wal.log")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[ends[2]-1] ^= 0xff // last payload byte of the third record
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, got := openWAL(t, dir); !reflect.DeepEqual(got, want[:2]) {
+		t.Fatalf("recovered %q, want %q", got, want[:2])
+	}
+}
+
+// Ensure a damaged snapshot is reported rather than silently dropping records.
+func TestWAL_CorruptSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := openWAL(t, dir)
+	if err := w.Compact(func() [][]byte { return makeRecords(3) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "snapshot.dat")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := wal.Open(dir, wal.Options{}); err == nil {
+		t.Fatal("expected error opening WAL with corrupt snapshot")
+	}
+}
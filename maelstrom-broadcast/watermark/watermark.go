@@ -0,0 +1,93 @@
+package watermark
+
+import (
+	"sync"
+)
+
+// mark is the acknowledged position of a single neighbor in our log.
+type mark struct {
+	incarnation string // The neighbor's incarnation when it acknowledged version.
+	version     int    // The highest log version the neighbor has acknowledged.
+	resetAt     int    // Our log version when the neighbor was last seen to restart.
+}
+
+// Marks tracks, for every neighbor, the highest version of our log that the neighbor
+// has acknowledged. Gossip only has to send the entries after a neighbor's mark.
+//
+// Each neighbor reports an incarnation identifier that changes whenever it restarts.
+// If the incarnation in an acknowledgement does not match the one we recorded, the neighbor
+// has lost its state and its mark is reset so the whole log is sent again. That includes the
+// entries the neighbor gossiped to us itself, which are otherwise never sent back to it.
+type Marks struct {
+	mu    sync.Mutex      // mu guards access to marks.
+	marks map[string]mark // Marks keyed by neighbor node ID.
+}
+
+// NewMarks initializes and returns a pointer to a new, empty Marks.
+func NewMarks() *Marks {
+	return &Marks{
+		marks: make(map[string]mark),
+	}
+}
+
+// Get returns the version of our log that neighbor has acknowledged, and the version our log was
+// at when the neighbor was last seen to restart. Entries up to resetAt must be sent to the
+// neighbor even if it was the one that gossiped them to us, since it may have lost them.
+// Neighbors that have never acknowledged anything are at version 0.
+func (m *Marks) Get(neighbor string) (version, resetAt int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	curr := m.marks[neighbor]
+	return curr.version, curr.resetAt
+}
+
+// Ack records that neighbor, running as incarnation, has received the entries of our log
+// in the range (from, to]. head is the current version of our log. It returns the neighbor's
+// mark after the acknowledgement.
+//
+// The mark only moves forward when the acknowledged range starts at or below the current mark,
+// so out-of-order or stale acknowledgements can never leave a gap. When the incarnation differs
+// from the recorded one, the mark is reset to 0 before the range is applied, and head is
+// recorded as the version the neighbor restarted at: every entry it gossiped to us before it
+// restarted is at or below head.
+func (m *Marks) Ack(neighbor, incarnation string, from, to, head int) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	curr := m.marks[neighbor]
+	if curr.incarnation != incarnation {
+		curr = mark{incarnation: incarnation, resetAt: head}
+	}
+
+	if from <= curr.version && to > curr.version {
+		curr.version = to
+	}
+
+	m.marks[neighbor] = curr
+	return curr.version
+}
+
+// Advance moves neighbor's mark from `from` to `to` without an acknowledgement, keeping the
+// recorded incarnation. It is used when every entry in the range was learned from the neighbor
+// itself, so there is nothing to send but the neighbor is known to have the entries already.
+func (m *Marks) Advance(neighbor string, from, to int) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	curr := m.marks[neighbor]
+	if from <= curr.version && to > curr.version {
+		curr.version = to
+	}
+
+	m.marks[neighbor] = curr
+	return curr.version
+}
+
+// Reset forgets everything neighbor has acknowledged, forcing the next gossip round
+// to send it our whole log.
+func (m *Marks) Reset(neighbor string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.marks, neighbor)
+}
@@ -0,0 +1,88 @@
+package watermark_test
+
+import (
+	"testing"
+
+	"maelstrom-broadcast/watermark"
+)
+
+func TestMarks_Ack(t *testing.T) {
+	t.Run("Contiguous", func(t *testing.T) {
+		m := watermark.NewMarks()
+		if got := m.Ack("n2", "a", 0, 3, 7); got != 3 {
+			t.Fatalf("mark=%d, want 3", got)
+		}
+		if got := m.Ack("n2", "a", 3, 5, 7); got != 5 {
+			t.Fatalf("mark=%d, want 5", got)
+		}
+	})
+
+	t.Run("StaleAckIgnored", func(t *testing.T) {
+		m := watermark.NewMarks()
+		m.Ack("n2", "a", 0, 5, 7)
+		if got := m.Ack("n2", "a", 0, 3, 7); got != 5 {
+			t.Fatalf("mark=%d, want 5", got)
+		}
+	})
+
+	t.Run("GapIgnored", func(t *testing.T) {
+		m := watermark.NewMarks()
+		m.Ack("n2", "a", 0, 2, 7)
+		if got := m.Ack("n2", "a", 4, 6, 7); got != 2 {
+			t.Fatalf("mark=%d, want 2", got)
+		}
+	})
+
+	// A new incarnation means the neighbor restarted, so only a range starting at 0 counts.
+	t.Run("ResetOnNewIncarnation", func(t *testing.T) {
+		m := watermark.NewMarks()
+		m.Ack("n2", "a", 0, 5, 7)
+		if got := m.Ack("n2", "b", 5, 7, 7); got != 0 {
+			t.Fatalf("mark=%d, want 0", got)
+		}
+		if got := m.Ack("n2", "b", 0, 7, 7); got != 7 {
+			t.Fatalf("mark=%d, want 7", got)
+		}
+	})
+
+	// Entries up to the log's version at the restart are resent, even those the neighbor sent us.
+	t.Run("ResetAtOnNewIncarnation", func(t *testing.T) {
+		m := watermark.NewMarks()
+		m.Ack("n2", "a", 0, 5, 5)
+		if _, resetAt := m.Get("n2"); resetAt != 5 {
+			t.Fatalf("resetAt=%d after first ack, want 5", resetAt)
+		}
+		m.Ack("n2", "b", 5, 5, 9)
+		if version, resetAt := m.Get("n2"); version != 0 || resetAt != 9 {
+			t.Fatalf("mark=%d, resetAt=%d, want 0 and 9", version, resetAt)
+		}
+
+		// Later acks from the same incarnation keep it.
+		m.Ack("n2", "b", 0, 9, 12)
+		if version, resetAt := m.Get("n2"); version != 9 || resetAt != 9 {
+			t.Fatalf("mark=%d, resetAt=%d, want 9 and 9", version, resetAt)
+		}
+	})
+}
+
+func TestMarks_Advance(t *testing.T) {
+	m := watermark.NewMarks()
+	m.Ack("n2", "a", 0, 2, 7)
+	if got := m.Advance("n2", 2, 4); got != 4 {
+		t.Fatalf("mark=%d, want 4", got)
+	}
+
+	// Advancing keeps the incarnation, so a matching ack continues from the new mark.
+	if got := m.Ack("n2", "a", 4, 6, 7); got != 6 {
+		t.Fatalf("mark=%d, want 6", got)
+	}
+}
+
+func TestMarks_Reset(t *testing.T) {
+	m := watermark.NewMarks()
+	m.Ack("n2", "a", 0, 5, 7)
+	m.Reset("n2")
+	if got, _ := m.Get("n2"); got != 0 {
+		t.Fatalf("mark=%d, want 0", got)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+newKVStore returns a client for the key-value service that KV mode keeps the counter in.
+
+Parameters:
+  - n: the Maelstrom node running the counter.
+  - backend: "lin" for lin-kv, "seq" for seq-kv or "lww" for lww-kv.
+
+Returns:
+  - The client.
+  - An error if the backend is unknown.
+
+lin-kv and seq-kv hold each node's key as a counterValue updated with compare-and-swap. lww-kv's
+compare-and-swap runs against one of several independent replicas, whose writes are later merged
+by timestamp, so two successful compare-and-swaps can still lose one another's update. On lww-kv
+each node therefore keeps its count in memory and blindly writes it, versioned, to its key; see
+ownedCounter.
+*/
+func newKVStore(n *maelstrom.Node, backend string) (*maelstrom.KV, error) {
+	switch backend {
+	case "lin":
+		return maelstrom.NewLinKV(n), nil
+	case "seq":
+		return maelstrom.NewSeqKV(n), nil
+	case "lww":
+		return maelstrom.NewLWWKV(n), nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q", backend)
+	}
+}
+
+// autoReadConsistency returns the read consistency that keeps final reads correct on backend.
+// Only seq-kv needs a barrier: lin-kv reads are fresh, and on lww-kv a node's reads are made
+// monotonic by ownedCounter instead.
+func autoReadConsistency(backend string) string {
+	if backend == "seq" {
+		return "epoch"
+	}
+	return "stale"
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"maelstrom-counter/optimistic"
+)
+
+// applyFunc applies a batch of adds to a node's counter key, returning once they are applied.
+type applyFunc func(ctx context.Context, key string, adds []pendingAdd) error
+
+/*
+casApply returns an applyFunc that applies a batch with a single compare-and-swap, retried as
+updater allows, and records the adds' IDs in the key, keeping the last window of them.
+*/
+func casApply(updater *optimistic.Updater, window int) applyFunc {
+	return func(ctx context.Context, key string, adds []pendingAdd) error {
+		return optimistic.Update(ctx, updater, key, func(curr counterValue, _ bool) (counterValue, error) {
+			return curr.with(adds, window), nil
+		})
+	}
+}
+
+// addBatcher coalesces the adds made on one node into a single batch, which one flusher applies
+// to the node's counter key once per interval, with a single compare-and-swap on lin-kv and
+// seq-kv. Concurrent adds therefore no longer fail each other's preconditions. It is safe for
+// concurrent use.
+type addBatcher struct {
+	apply applyFunc
+
+	mu      sync.Mutex   // mu guards access to pending and waiters.
+	pending []pendingAdd // Adds made since the last flush.
+	waiters []waiter     // One per add waiting for pending to be applied.
+}
+
+// waiter is an add waiting for a flush.
+type waiter struct {
+	deadline time.Time  // The add's request deadline.
+	done     chan error // Receives the outcome of the flush.
+}
+
+// newAddBatcher initializes and returns a pointer to a new addBatcher applying batches with apply.
+func newAddBatcher(apply applyFunc) *addBatcher {
+	return &addBatcher{apply: apply}
+}
+
+// add queues an add with the given ID and delta for the next flush, which must finish before
+// deadline. The returned channel receives nil once the add has been applied, or found to have been
+// applied already, or the error that prevented it.
+func (b *addBatcher) add(deadline time.Time, id string, delta int) <-chan error {
+	done := make(chan error, 1)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = append(b.pending, pendingAdd{id: id, delta: delta})
+	b.waiters = append(b.waiters, waiter{deadline: deadline, done: done})
+
+	return done
+}
+
+// run flushes the pending adds to key every interval. It never returns.
+func (b *addBatcher) run(key string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		b.flush(key)
+	}
+}
+
+/*
+flush applies the pending adds to key and reports the outcome to every add waiting on it.
+
+An add is only acknowledged once its delta has been applied. If the flush fails or times out,
+every waiting add receives the error, just as if it had run its own compare-and-swap, and the
+delta is not carried into the next flush, so it is never applied twice.
+*/
+func (b *addBatcher) flush(key string) {
+	b.mu.Lock()
+	adds, waiters := b.pending, b.waiters
+	b.pending, b.waiters = nil, nil
+	b.mu.Unlock()
+
+	if len(waiters) == 0 {
+		return
+	}
+
+	// The flush must finish before the earliest deadline of the adds waiting on it.
+	deadline := waiters[0].deadline
+	for _, w := range waiters[1:] {
+		if w.deadline.Before(deadline) {
+			deadline = w.deadline
+		}
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	err := b.apply(ctx, key, adds)
+	for _, w := range waiters {
+		w.done <- err
+	}
+}
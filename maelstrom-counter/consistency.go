@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"maelstrom-counter/optimistic"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// readBarrier is run by a KV-mode read before it reads the counter keys. Once it returns, the
+// store must not serve this node anything older than the writes acknowledged before it started.
+type readBarrier func(ctx context.Context) error
+
+/*
+newReadBarrier returns the read barrier for a read-consistency mode.
+
+Parameters:
+  - n: the Maelstrom node running the counter.
+  - kv: the key-value store holding the counter keys.
+  - updater: advances the epoch in "epoch" mode.
+  - mode: "stale", "nonce" or "epoch".
+
+Returns:
+  - The barrier, which does nothing for the "stale" mode.
+  - An error if the mode is unknown.
+
+seq-kv only promises that all clients observe its operations in one order, consistent with the
+order each client issued them in, so a node that has only read may keep reading an old state
+forever. Writing a key forces the store to place the write, and every later operation by this
+node, after everything it has already acknowledged: "nonce" writes a fresh value to a per-node
+key, and "epoch" compare-and-swaps a per-node epoch forward, which additionally fails and retries
+if the epoch it read was stale. Other backends need no barrier; see autoReadConsistency.
+*/
+func newReadBarrier(n *maelstrom.Node, kv *maelstrom.KV, updater *optimistic.Updater, mode string) (readBarrier, error) {
+	switch mode {
+	case "stale":
+		return func(ctx context.Context) error { return nil }, nil
+
+	case "nonce":
+		var nonce atomic.Int64
+		return func(ctx context.Context) error {
+			key := fmt.Sprintf("nonce-%s", n.ID())
+			return kv.Write(ctx, key, fmt.Sprintf("%s-%d", n.ID(), nonce.Add(1)))
+		}, nil
+
+	case "epoch":
+		return func(ctx context.Context) error {
+			key := fmt.Sprintf("epoch-%s", n.ID())
+			return optimistic.Update(ctx, updater, key, func(epoch int, _ bool) (int, error) {
+				return epoch + 1, nil
+			})
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown read consistency %q", mode)
+	}
+}
+
+/*
+resolveLegacyReadConsistency rewrites the deprecated read consistency "lin", which kept the counter
+keys in lin-kv before there was a -backend flag, into the configuration it stands for: the lin
+backend with the "stale" read consistency, since lin-kv needs no barrier.
+
+Parameters:
+  - cfg: the configuration, updated in place.
+
+Returns:
+  - An error if "lin" is combined with a backend other than lin-kv or the default seq-kv.
+*/
+func resolveLegacyReadConsistency(cfg *config) error {
+	if cfg.readConsistency != "lin" {
+		return nil
+	}
+	if cfg.backend != "seq" && cfg.backend != "lin" {
+		return fmt.Errorf("read consistency \"lin\" is deprecated and means -backend lin -read-consistency stale, which conflicts with -backend %s", cfg.backend)
+	}
+
+	log.Printf("-read-consistency lin is deprecated: use -backend lin, whose reads need no barrier")
+	cfg.backend, cfg.readConsistency = "lin", "stale"
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"maelstrom-counter/pncounter"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// mergeMessageBody is the body of a "merge" message carrying a node's whole CRDT state.
+// It is sent with Send and never acknowledged: a lost merge is repaired by the next one.
+type mergeMessageBody struct {
+	maelstrom.MessageBody
+	pncounter.State
+}
+
+// crdtServer serves the counter from a PN-counter CRDT replicated by gossip, without any KV store.
+type crdtServer struct {
+	n       *maelstrom.Node
+	counter *pncounter.PNCounter
+}
+
+// newCRDTServer initializes and returns a pointer to a new crdtServer running on node n.
+func newCRDTServer(n *maelstrom.Node) *crdtServer {
+	return &crdtServer{
+		n:       n,
+		counter: pncounter.New(),
+	}
+}
+
+// register registers the server's handlers. Once the node is initialized and knows the other
+// nodes, it starts gossiping its state every interval.
+func (s *crdtServer) register(interval time.Duration) {
+	s.n.Handle("init", func(msg maelstrom.Message) error {
+		go s.runGossip(interval)
+		return nil
+	})
+	s.n.Handle("add", s.handleAdd)
+	s.n.Handle("read", s.handleRead)
+	s.n.Handle("merge", s.handleMerge)
+}
+
+// handleAdd adds a signed delta to this node's entries in the counter vectors.
+func (s *crdtServer) handleAdd(msg maelstrom.Message) error {
+	var body struct {
+		Delta *int `json:"delta"`
+	}
+
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+	}
+	if body.Delta == nil {
+		return maelstrom.NewRPCError(maelstrom.MalformedRequest, "delta must be an integer")
+	}
+
+	if err := s.counter.Add(s.n.ID(), *body.Delta); err != nil {
+		return maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf("can't add %d: %v", *body.Delta, err))
+	}
+
+	return s.n.Reply(msg, maelstrom.MessageBody{Type: "add_ok"})
+}
+
+// handleRead answers with the local value of the counter, without contacting any other node.
+func (s *crdtServer) handleRead(msg maelstrom.Message) error {
+	return s.n.Reply(msg, map[string]any{
+		"type":  "read_ok",
+		"value": s.counter.Value(),
+	})
+}
+
+// handleMerge folds another node's state into ours. Errors are logged rather than returned,
+// since merge messages have no msg_id to reply to.
+func (s *crdtServer) handleMerge(msg maelstrom.Message) error {
+	var body mergeMessageBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		log.Printf("Malformed merge from %s: %v", msg.Src, err)
+		return nil
+	}
+
+	s.counter.Merge(body.State)
+	return nil
+}
+
+// runGossip sends this node's whole state to every other node each interval. It never returns.
+func (s *crdtServer) runGossip(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		body := mergeMessageBody{
+			MessageBody: maelstrom.MessageBody{Type: "merge"},
+			State:       s.counter.State(),
+		}
+
+		for _, id := range s.n.NodeIDs() {
+			if id == s.n.ID() {
+				continue
+			}
+			if err := s.n.Send(id, body); err != nil {
+				log.Printf("Error sending merge to %s: %v", id, err)
+			}
+		}
+	}
+}
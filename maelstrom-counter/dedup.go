@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+)
+
+// counterValue is the value of a node's counter key. The IDs of the adds it includes are stored
+// alongside the count, so that one compare-and-swap both applies an add and records it, and a
+// retried add can't be applied twice.
+type counterValue struct {
+	Count   int      `json:"count"`
+	Applied []string `json:"applied,omitempty"` // IDs of the most recently applied adds, oldest first.
+	Version int      `json:"version,omitempty"` // Only used on lww-kv; see ownedCounter.
+}
+
+// pendingAdd is an add waiting to be applied to a counter key.
+type pendingAdd struct {
+	id    string // Identifies the request; retries of the same request share it. Empty if unknown.
+	delta int
+}
+
+/*
+addID returns the identifier used to deduplicate an add request.
+
+Parameters:
+  - src: the node or client that sent the request.
+  - msgID: the request's msg_id.
+  - key: the request's optional "idempotency_key".
+
+Returns:
+  - "key:" followed by key, if the client supplied one; it then identifies the add no matter who
+    sends it. Otherwise "msg:" followed by src and msgID, which a proxy retrying the request
+    preserves. An empty string if neither is available, in which case the add isn't deduplicated.
+*/
+func addID(src string, msgID int, key string) string {
+	switch {
+	case key != "":
+		return "key:" + key
+	case msgID != 0:
+		return fmt.Sprintf("msg:%s/%d", src, msgID)
+	default:
+		return ""
+	}
+}
+
+/*
+with returns v with adds applied.
+
+Parameters:
+  - adds: the adds to apply, in order. An add whose ID was already applied, by v or earlier in
+    adds, is skipped.
+  - window: how many of the most recent IDs to keep. Older IDs are forgotten, so a retry of an
+    add that arrives after window newer adds on the same key is applied again.
+
+Returns:
+  - The new value. v is not modified.
+*/
+func (v counterValue) with(adds []pendingAdd, window int) counterValue {
+	seen := make(map[string]bool, len(v.Applied)+len(adds))
+	for _, id := range v.Applied {
+		seen[id] = true
+	}
+
+	next := counterValue{
+		Count:   v.Count,
+		Applied: append([]string(nil), v.Applied...),
+		Version: v.Version,
+	}
+	for _, a := range adds {
+		if a.id != "" && seen[a.id] {
+			continue
+		}
+
+		next.Count += a.delta
+		if a.id != "" {
+			seen[a.id] = true
+			next.Applied = append(next.Applied, a.id)
+		}
+	}
+
+	if len(next.Applied) > window {
+		next.Applied = next.Applied[len(next.Applied)-window:]
+	}
+	if len(next.Applied) == 0 {
+		next.Applied = nil // Keep the stored JSON identical to what reading it back produces.
+	}
+	return next
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCounterValue_With(t *testing.T) {
+	v := counterValue{Count: 10, Applied: []string{"a", "b"}}
+
+	got := v.with([]pendingAdd{
+		{id: "b", delta: 100}, // Already applied.
+		{id: "c", delta: 1},
+		{id: "", delta: 2},  // No ID: always applied, never recorded.
+		{id: "c", delta: 1}, // A retry within the same batch.
+		{id: "", delta: 3},
+	}, 10)
+
+	want := counterValue{Count: 16, Applied: []string{"a", "b", "c"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if !reflect.DeepEqual(v.Applied, []string{"a", "b"}) {
+		t.Fatalf("with modified its receiver: %+v", v)
+	}
+}
+
+// Ensure only the most recent window IDs are kept, so a retry arriving after that many newer
+// adds is applied again.
+func TestCounterValue_WithWindow(t *testing.T) {
+	v := counterValue{}
+	for _, id := range []string{"a", "b", "c", "d"} {
+		v = v.with([]pendingAdd{{id: id, delta: 1}}, 2)
+	}
+	if want := []string{"c", "d"}; !reflect.DeepEqual(v.Applied, want) {
+		t.Fatalf("applied=%v, want %v", v.Applied, want)
+	}
+
+	v = v.with([]pendingAdd{{id: "d", delta: 1}, {id: "a", delta: 1}}, 2)
+	if v.Count != 5 {
+		t.Fatalf("count=%d, want 5", v.Count)
+	}
+
+	if v = v.with(nil, 0); v.Applied != nil {
+		t.Fatalf("applied=%v with a window of 0, want nil", v.Applied)
+	}
+}
+
+func TestAddID(t *testing.T) {
+	for _, tt := range []struct {
+		src   string
+		msgID int
+		key   string
+		want  string
+	}{
+		{"c1", 7, "", "msg:c1/7"},
+		{"c1", 7, "k", "key:k"},
+		{"c2", 9, "k", "key:k"},
+		{"c1", 0, "", ""},
+	} {
+		if got := addID(tt.src, tt.msgID, tt.key); got != tt.want {
+			t.Errorf("addID(%q, %d, %q)=%q, want %q", tt.src, tt.msgID, tt.key, got, tt.want)
+		}
+	}
+}
+
+// Ensure adds retried with the same idempotency key are counted once, whether the retry arrives
+// after the first attempt committed or while it is still waiting for a flush.
+func TestKV_IdempotentAdds(t *testing.T) {
+	net, ids := newCluster(t, kvConfig("seq", "stale"), 1)
+
+	call := func(key string, delta int) {
+		t.Helper()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		body := map[string]any{"type": "add", "delta": delta, "idempotency_key": key}
+		if _, err := net.Call(ctx, ids[0], body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	call("a", 5)
+	call("a", 5)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := net.Call(ctx, ids[0], map[string]any{"type": "add", "delta": 3, "idempotency_key": "b"}); err != nil {
+			t.Error(err)
+		}
+	}()
+	call("b", 3)
+	<-done
+
+	add(t, net, ids[0], 1)
+	if got, want := read(t, net, ids[0]), 9; got != want {
+		t.Fatalf("read %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+package gcounter
+
+import (
+	"errors"
+	"math"
+	"sync"
+)
+
+var (
+	// ErrNegativeDelta is returned by Add for a negative delta.
+	ErrNegativeDelta = errors.New("gcounter: negative delta")
+
+	// ErrOverflow is returned by Add when a node's count would exceed the largest int.
+	ErrOverflow = errors.New("gcounter: count overflows")
+)
+
+// GCounter is a state-based grow-only counter CRDT. Every node only ever increments its own
+// entry in a vector of per-node counts, and two replicas merge by taking the element-wise
+// maximum, so merges are commutative, associative and idempotent. The counter's value is the
+// sum of the vector. It is safe for concurrent use.
+type GCounter struct {
+	mu     sync.RWMutex   // mu guards access to counts.
+	counts map[string]int // Per-node counts, keyed by node ID.
+}
+
+// New initializes and returns a pointer to a new GCounter with a value of 0.
+func New() *GCounter {
+	return &GCounter{
+		counts: make(map[string]int),
+	}
+}
+
+// Add increments node's entry by delta. It returns ErrNegativeDelta if delta is negative and
+// ErrOverflow if the entry would overflow, in which case the entry is unchanged.
+func (g *GCounter) Add(node string, delta int) error {
+	if delta < 0 {
+		return ErrNegativeDelta
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.counts[node] > math.MaxInt-delta {
+		return ErrOverflow
+	}
+	g.counts[node] += delta
+	return nil
+}
+
+// Merge folds another replica's counts into this one by taking the element-wise maximum.
+func (g *GCounter) Merge(counts map[string]int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for node, c := range counts {
+		if c > g.counts[node] {
+			g.counts[node] = c
+		}
+	}
+}
+
+// State returns a copy of the per-node counts, suitable for sending to other replicas.
+func (g *GCounter) State() map[string]int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	counts := make(map[string]int, len(g.counts))
+	for node, c := range g.counts {
+		counts[node] = c
+	}
+	return counts
+}
+
+// Value returns the current value of the counter: the sum of all per-node counts.
+func (g *GCounter) Value() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	total := 0
+	for _, c := range g.counts {
+		total += c
+	}
+	return total
+}
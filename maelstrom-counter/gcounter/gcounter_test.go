@@ -0,0 +1,72 @@
+package gcounter_test
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"maelstrom-counter/gcounter"
+)
+
+func TestGCounter_Add(t *testing.T) {
+	g := gcounter.New()
+	g.Add("n1", 3)
+	g.Add("n2", 4)
+	g.Add("n1", 5)
+
+	if got, want := g.Value(), 12; got != want {
+		t.Fatalf("value=%d, want %d", got, want)
+	}
+	if got, want := g.State(), map[string]int{"n1": 8, "n2": 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("state=%v, want %v", got, want)
+	}
+}
+
+func TestGCounter_AddErrors(t *testing.T) {
+	g := gcounter.New()
+	if err := g.Add("n1", -1); !errors.Is(err, gcounter.ErrNegativeDelta) {
+		t.Fatalf("add -1: err=%v, want ErrNegativeDelta", err)
+	}
+	if err := g.Add("n1", math.MaxInt); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Add("n1", 1); !errors.Is(err, gcounter.ErrOverflow) {
+		t.Fatalf("add past MaxInt: err=%v, want ErrOverflow", err)
+	}
+	if got := g.Value(); got != math.MaxInt {
+		t.Fatalf("value=%d after failed adds, want %d", got, math.MaxInt)
+	}
+}
+
+// Ensure merging is commutative and idempotent, so replicas converge no matter how often
+// or in what order they exchange state.
+func TestGCounter_Merge(t *testing.T) {
+	a, b := gcounter.New(), gcounter.New()
+	a.Add("n1", 5)
+	a.Add("n2", 1)
+	b.Add("n2", 7)
+	b.Add("n3", 2)
+
+	ab := gcounter.New()
+	ab.Merge(a.State())
+	ab.Merge(b.State())
+
+	ba := gcounter.New()
+	ba.Merge(b.State())
+	ba.Merge(a.State())
+	ba.Merge(a.State())
+
+	if !reflect.DeepEqual(ab.State(), ba.State()) {
+		t.Fatalf("merge order matters: %v != %v", ab.State(), ba.State())
+	}
+	if got, want := ab.Value(), 14; got != want {
+		t.Fatalf("value=%d, want %d", got, want)
+	}
+
+	// A stale state must never move a count backwards.
+	ab.Merge(map[string]int{"n2": 0})
+	if got, want := ab.Value(), 14; got != want {
+		t.Fatalf("value after stale merge=%d, want %d", got, want)
+	}
+}
@@ -2,4 +2,4 @@ module maelstrom-counter
 
 go 1.24.2
 
-require github.com/jepsen-io/maelstrom/demo/go v0.0.0-20250204203845-8263d1dd2b7a // indirect
+require github.com/jepsen-io/maelstrom/demo/go v0.0.0-20250204203845-8263d1dd2b7a
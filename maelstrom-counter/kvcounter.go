@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"maelstrom-counter/optimistic"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+registerKVHandlers registers the 'add' and 'read' handlers for the KV mode of the counter.
+
+Parameters:
+  - n: the Maelstrom node to register the handlers on.
+  - kv: the key-value store holding one counter key per node.
+  - cfg: the counter's configuration.
+
+Returns:
+  - An error if the configured read consistency is unknown.
+
+Each node writes to its own key, e.g. `counter-n1`, and a read sums the keys of every node in the
+cluster, counting a node that has never written as 0. A key holds the net sum of its node's signed
+deltas, so decrements need no special handling and a count may go negative. On lin-kv and seq-kv
+it is updated with compare-and-swap, along with the IDs of the last cfg.dedupWindow adds it
+applied; see counterValue. On lww-kv it is written by its node alone; see ownedCounter.
+Every request must be answered within cfg.requestTimeout, and compare-and-swaps are retried as
+cfg.retry allows.
+*/
+func registerKVHandlers(n *maelstrom.Node, kv *maelstrom.KV, cfg config) error {
+	updater := optimistic.NewUpdater(kv, cfg.retry)
+
+	barrier, err := newReadBarrier(n, kv, updater, cfg.readConsistency)
+	if err != nil {
+		return err
+	}
+
+	// Pick how adds are applied and keys summed for the backend.
+	var (
+		batcher *addBatcher
+		owner   *ownedCounter
+	)
+	if cfg.backend == "lww" {
+		owner = newOwnedCounter(kv, cfg.dedupWindow, cfg.retry)
+		batcher = newAddBatcher(owner.apply)
+	} else {
+		batcher = newAddBatcher(casApply(updater, cfg.dedupWindow))
+	}
+
+	// Start flushing adds once the node knows its ID.
+	n.Handle("init", func(msg maelstrom.Message) error {
+		/*
+			Make writes to the key belonging to this node.
+			Utilize the node ID so that nodes have less competition for writes.
+		*/
+		key := fmt.Sprintf("counter-%s", n.ID())
+		go batcher.run(key, cfg.flushInterval)
+		if owner != nil {
+			go owner.refresh(key)
+		}
+		return nil
+	})
+
+	// Handle the 'add' message type
+	n.Handle("add", func(msg maelstrom.Message) error {
+		// Read in request value 'delta' as a signed int. Decoding it into an int rather than
+		// truncating a float64 rejects fractional deltas and keeps large ones exact.
+		var req struct {
+			MsgID          int    `json:"msg_id"`
+			Delta          *int   `json:"delta"`
+			IdempotencyKey string `json:"idempotency_key"`
+		}
+		if err := json.Unmarshal(msg.Body, &req); err != nil {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+		if req.Delta == nil {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, "delta must be an integer")
+		}
+		delta := *req.Delta
+
+		// Queue the delta for the next flush to the key belonging to this node, and wait for it.
+		// The flush skips it if a retry of the same request was already applied.
+		id := addID(msg.Src, req.MsgID, req.IdempotencyKey)
+		if err := <-batcher.add(time.Now().Add(cfg.requestTimeout), id, delta); err != nil {
+			return err
+		}
+
+		// n.Reply fills in in_reply_to from the request's msg_id.
+		res := map[string]any{
+			"type":   "add_ok",
+			"msg_id": req.MsgID,
+		}
+
+		return n.Reply(msg, res)
+	})
+
+	// Handle the 'read' message type
+	n.Handle("read", func(msg maelstrom.Message) error {
+		var body map[string]any
+
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.requestTimeout)
+		defer cancel()
+
+		if err := barrier(ctx); err != nil {
+			return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf("read barrier: %v", err))
+		}
+
+		// Read every node's key at once, so that a read takes one round trip however large the cluster.
+		keys := make([]string, 0, len(n.NodeIDs()))
+		for _, id := range n.NodeIDs() {
+			keys = append(keys, fmt.Sprintf("counter-%s", id))
+		}
+
+		var total int
+		if owner != nil {
+			total, err = owner.sum(ctx, fmt.Sprintf("counter-%s", n.ID()), keys)
+		} else {
+			total, err = sumKeys(ctx, kv, keys, cfg.retry)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Remove message field from response if it exists
+		res := map[string]any{
+			"type":        "read_ok",
+			"value":       total,
+			"msg_id":      body["msg_id"],
+			"in_reply_to": body["in_reply_to"],
+		}
+
+		return n.Reply(msg, res)
+	})
+
+	// Handle the 'stats' message type, which reports how often compare-and-swaps were retried
+	n.Handle("stats", func(msg maelstrom.Message) error {
+		return n.Reply(msg, statsOKMessageBody{
+			Type: "stats_ok",
+			CAS:  updater.Stats(),
+		})
+	})
+
+	return nil
+}
+
+// statsOKMessageBody is the reply to a "stats" message.
+type statsOKMessageBody struct {
+	Type string           `json:"type"`
+	CAS  optimistic.Stats `json:"cas"`
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"maelstrom-counter/optimistic"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// lwwRefreshInterval is how often a node rewrites its count to lww-kv even if it hasn't changed.
+const lwwRefreshInterval = 100 * time.Millisecond
+
+/*
+ownedCounter keeps the counter on lww-kv, where compare-and-swap can't be trusted.
+
+Each node is the only writer of its own key and keeps the authoritative count, and the IDs of
+the adds it applied, in memory. Every flush bumps a version and blindly writes the count and
+version to the key, and the node rewrites them every lwwRefreshInterval. lww-kv may let an older
+write win for a while, since its replicas' clocks differ, but a rewrite made long enough after
+the last change carries the newest timestamp and eventually wins everywhere.
+
+Readers remember the highest version they have read of every other node's key and use it
+instead of an older one, so a node's reads never go backwards. A node's own key is never read
+back. The count is lost if a node restarts, since lww-kv can't tell it which version is the
+latest. It is safe for concurrent use.
+*/
+type ownedCounter struct {
+	kv     *maelstrom.KV
+	window int               // Applied add IDs kept; see counterValue.with.
+	policy optimistic.Policy // How key reads are retried.
+
+	mu     sync.Mutex              // mu guards access to value and latest.
+	value  counterValue            // This node's count.
+	latest map[string]counterValue // Highest version read of every other node's key.
+}
+
+// newOwnedCounter initializes and returns a pointer to a new ownedCounter writing to kv.
+func newOwnedCounter(kv *maelstrom.KV, window int, policy optimistic.Policy) *ownedCounter {
+	return &ownedCounter{
+		kv:     kv,
+		window: window,
+		policy: policy,
+		latest: make(map[string]counterValue),
+	}
+}
+
+// apply is an applyFunc. It applies the adds in memory and then writes the count, returning the
+// write's error, so an add is only acknowledged once a write of its count has succeeded. The adds
+// stay applied in memory even if the write fails: a retry is recognized by its ID, and is
+// acknowledged once a later flush writes the count.
+func (o *ownedCounter) apply(ctx context.Context, key string, adds []pendingAdd) error {
+	o.mu.Lock()
+	o.value = o.value.with(adds, o.window)
+	o.value.Version++
+	o.mu.Unlock()
+
+	return o.write(ctx, key)
+}
+
+// refresh rewrites this node's count to key every lwwRefreshInterval. It never returns.
+func (o *ownedCounter) refresh(key string) {
+	ticker := time.NewTicker(lwwRefreshInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), lwwRefreshInterval)
+		if err := o.write(ctx, key); err != nil {
+			log.Printf("Error refreshing %s: %v", key, err)
+		}
+		cancel()
+	}
+}
+
+// write writes this node's count and version, without the applied IDs, to key.
+func (o *ownedCounter) write(ctx context.Context, key string) error {
+	o.mu.Lock()
+	v := counterValue{Count: o.value.Count, Version: o.value.Version}
+	o.mu.Unlock()
+
+	if v.Version == 0 {
+		return nil // Nothing has been added yet.
+	}
+	return o.kv.Write(ctx, key, v)
+}
+
+/*
+sum reads every counter key concurrently and returns the sum of the freshest counts known.
+
+Parameters:
+  - ctx: bounds the whole read, retries included.
+  - self: this node's key, whose count is taken from memory.
+  - keys: the counter keys to sum.
+
+Returns:
+  - The sum.
+  - An error as for readKeys.
+*/
+func (o *ownedCounter) sum(ctx context.Context, self string, keys []string) (int, error) {
+	others := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key != self {
+			others = append(others, key)
+		}
+	}
+
+	vals, err := readKeys(ctx, o.kv, others, o.policy)
+	if err != nil {
+		return 0, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	total := o.value.Count
+	for i, key := range others {
+		if vals[i].Version > o.latest[key].Version {
+			o.latest[key] = vals[i]
+		}
+		total += o.latest[key].Count
+	}
+	return total, nil
+}
@@ -1,110 +1,110 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"time"
+
+	"maelstrom-counter/optimistic"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
+// config holds the command-line options of the counter.
+type config struct {
+	mode            string            // "kv" or "crdt".
+	backend         string            // The KV-mode store: "lin", "seq" or "lww"; see newKVStore.
+	readConsistency string            // How KV-mode reads avoid stale values, or "auto"; see newReadBarrier.
+	flushInterval   time.Duration     // How often KV-mode adds are applied; see addBatcher.
+	requestTimeout  time.Duration     // How long a KV-mode request may take, compare-and-swap retries included.
+	retry           optimistic.Policy // How KV-mode compare-and-swaps and key reads are retried.
+	dedupWindow     int               // Applied add IDs each KV-mode counter key remembers; see counterValue.with.
+	gossipInterval  time.Duration     // How often CRDT state is sent to the other nodes.
+}
+
 /*
-This program...
+newCounterNode creates a Maelstrom node and registers the handlers for the configured mode.
+
+Parameters:
+  - cfg: the counter's configuration.
+
+Returns:
+  - The node, ready to be run.
+  - An error if the mode, backend or read consistency is unknown, or the flush interval, dedup
+    window or gossip interval is out of range.
 */
-func main() {
+func newCounterNode(cfg config) (*maelstrom.Node, error) {
 	// Initialize a new Maelstrom node for the program to run on.
 	n := maelstrom.NewNode()
 
-	// Initialize a key-value store to persist operations on even in the case of node failures.
-	kv := maelstrom.NewSeqKV(n)
-
-	// Handle the 'add' message type
-	n.Handle("add", func(msg maelstrom.Message) error {
-		var body map[string]any
-
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
+	switch cfg.mode {
+	case "kv":
+		if err := resolveLegacyReadConsistency(&cfg); err != nil {
+			return nil, err
 		}
 
-		// Read in request value 'delta' as an int
-		deltaFloat, ok := body["delta"].(float64)
-		if !ok {
-			return fmt.Errorf("delta is not a number")
+		// Initialize a key-value store to persist operations on even in the case of node failures.
+		kv, err := newKVStore(n, cfg.backend)
+		if err != nil {
+			return nil, err
 		}
-		delta := int(deltaFloat)
-
-		/*
-			Make a write to the key belonging to this node.
-			Utilize the node ID so that nodes have less competition for writes.
-		*/
-		key := fmt.Sprintf("counter-%s", n.ID())
-		for {
-			// Read the current value of the global counter "g_ct" from the kv store
-			curr_ct, err := kv.ReadInt(context.TODO(), key)
-			if rpcErr, ok := err.(*maelstrom.RPCError); ok && rpcErr.Code == maelstrom.KeyDoesNotExist {
-				curr_ct = 0
-			} else if err != nil {
-				return err
-			}
-
-			new_val := curr_ct + delta
-
-			err = kv.CompareAndSwap(context.TODO(), key, curr_ct, new_val, true)
-
-			if err == nil {
-				// Write succeeded
-				break
-			}
-
-			if rpcErr, ok := err.(*maelstrom.RPCError); !ok || rpcErr.Code != maelstrom.PreconditionFailed {
-				return err // Unrecoverable error
-			}
-
-			// Write failed, retry
+		if cfg.readConsistency == "auto" {
+			cfg.readConsistency = autoReadConsistency(cfg.backend)
 		}
 
-		// Remove message field from response if it exists
-		res := map[string]any{
-			"type":        "add_ok",
-			"msg_id":      body["msg_id"],
-			"in_reply_to": body["in_reply_to"],
+		if cfg.flushInterval <= 0 {
+			return nil, fmt.Errorf("flush interval must be positive, got %v", cfg.flushInterval)
 		}
-
-		return n.Reply(msg, res)
-	})
-
-	// Handle the 'read' message type
-	n.Handle("read", func(msg maelstrom.Message) error {
-		var body map[string]any
-
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
+		if cfg.dedupWindow < 0 {
+			return nil, fmt.Errorf("dedup window must not be negative, got %d", cfg.dedupWindow)
 		}
 
-		total := 0
-
-		for _, id := range n.NodeIDs() {
-			key := fmt.Sprintf("counter-%s", id)
-
-			val, err := kv.ReadInt(context.TODO(), key)
-			if err != nil {
-				return err
-			}
-
-			total += val
+		if err := registerKVHandlers(n, kv, cfg); err != nil {
+			return nil, err
 		}
-
-		// Remove message field from response if it exists
-		res := map[string]any{
-			"type":        "read_ok",
-			"value":       total,
-			"msg_id":      body["msg_id"],
-			"in_reply_to": body["in_reply_to"],
+	case "crdt":
+		if cfg.gossipInterval <= 0 {
+			return nil, fmt.Errorf("gossip interval must be positive, got %v", cfg.gossipInterval)
 		}
 
-		return n.Reply(msg, res)
-	})
+		newCRDTServer(n).register(cfg.gossipInterval)
+	default:
+		return nil, fmt.Errorf("unknown mode %q", cfg.mode)
+	}
+
+	return n, nil
+}
+
+/*
+This program implements a counter for the g-counter and pn-counter workloads. Deltas may be negative.
+
+With -mode kv (the default) the count lives in one of Maelstrom's key-value services, chosen with
+-backend, with one key per node. Since seq-kv reads may be stale, -read-consistency selects a
+barrier run before each read; by default, the one each backend needs.
+With -mode crdt no key-value store is used: each node keeps a PN-counter CRDT, a pair of vectors
+of per-node increments and decrements, answers reads locally and periodically gossips its vectors
+to every other node, which merges them by taking the element-wise maximum. Reads converge within
+one gossip interval.
+*/
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.mode, "mode", "kv", "where the count lives: kv (the key-value service chosen by -backend) or crdt (gossiped PN-counter)")
+	flag.StringVar(&cfg.backend, "backend", "seq", "kv mode: the key-value service to use: lin, seq or lww")
+	flag.StringVar(&cfg.readConsistency, "read-consistency", "auto", "kv mode: stale, nonce (write a nonce first), epoch (advance an epoch first) or auto (whatever the backend needs); lin is a deprecated alias for -backend lin")
+	flag.DurationVar(&cfg.flushInterval, "flush-interval", 10*time.Millisecond, "kv mode: how often the adds made on a node are applied together")
+	flag.DurationVar(&cfg.requestTimeout, "request-timeout", time.Second, "kv mode: how long a request may take before it fails")
+	flag.IntVar(&cfg.retry.MaxAttempts, "max-attempts", optimistic.DefaultPolicy.MaxAttempts, "kv mode: compare-and-swaps tried before an update fails as temporarily unavailable")
+	flag.DurationVar(&cfg.retry.BaseDelay, "backoff", optimistic.DefaultPolicy.BaseDelay, "kv mode: upper bound of the first backoff after a failed compare-and-swap")
+	flag.DurationVar(&cfg.retry.MaxDelay, "max-backoff", optimistic.DefaultPolicy.MaxDelay, "kv mode: largest upper bound of a backoff")
+	flag.IntVar(&cfg.dedupWindow, "dedup-window", 1000, "kv mode: how many applied add IDs each node remembers, so that retried adds aren't applied twice")
+	flag.DurationVar(&cfg.gossipInterval, "gossip-interval", 200*time.Millisecond, "crdt mode: how often state is sent to the other nodes")
+	flag.Parse()
+
+	n, err := newCounterNode(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Start the Maelstrom node, which listens for incoming messages.
 	if err := n.Run(); err != nil {
@@ -0,0 +1,562 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"maelstrom-counter/optimistic"
+	"maelstrom-counter/simnet"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestMain(m *testing.M) {
+	// maelstrom.Node logs every message it sends and receives.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// kvConfig returns the configuration of a KV-mode counter with the given backend and read consistency.
+func kvConfig(backend, readConsistency string) config {
+	return config{
+		mode:            "kv",
+		backend:         backend,
+		readConsistency: readConsistency,
+		flushInterval:   5 * time.Millisecond,
+		requestTimeout:  time.Second,
+		retry:           optimistic.DefaultPolicy,
+		dedupWindow:     100,
+	}
+}
+
+// newCluster starts count counter nodes with cfg on a fresh simnet.Network with stand-ins for
+// seq-kv, whose reads are as stale as possible, lin-kv and lww-kv, and initializes them.
+func newCluster(t *testing.T, cfg config, count int) (*simnet.Network, []string) {
+	t.Helper()
+
+	net := simnet.New()
+	net.AddService("seq-kv", simnet.NewSeqKV())
+	net.AddService("lin-kv", simnet.NewKV())
+	net.AddService("lww-kv", simnet.NewLWWKV(1))
+	t.Cleanup(net.Close)
+
+	ids := make([]string, count)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("n%d", i)
+
+		n, err := newCounterNode(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		net.AddNode(ids[i], n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := net.Init(ctx, ids); err != nil {
+		t.Fatal(err)
+	}
+
+	return net, ids
+}
+
+// add sends an "add" request to node id and fails the test if it isn't acknowledged.
+func add(t *testing.T, net *simnet.Network, id string, delta int) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := net.Call(ctx, id, map[string]any{"type": "add", "delta": delta}); err != nil {
+		t.Fatalf("add %d to %s: %v", delta, id, err)
+	}
+}
+
+// read sends a "read" request to node id and returns the value.
+func read(t *testing.T, net *simnet.Network, id string) int {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m, err := net.Call(ctx, id, map[string]any{"type": "read"})
+	if err != nil {
+		t.Fatalf("read from %s: %v", id, err)
+	}
+
+	var body struct {
+		Value int `json:"value"`
+	}
+	if err := json.Unmarshal(m.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Value
+}
+
+// waitForValue polls every node until they all read want, failing the test after timeout.
+func waitForValue(t *testing.T, net *simnet.Network, ids []string, want int, timeout time.Duration) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		converged := true
+		for _, id := range ids {
+			if got := read(t, net, id); got != want {
+				converged = false
+				if time.Now().After(deadline) {
+					t.Fatalf("%s read %d, want %d", id, got, want)
+				}
+			}
+		}
+		if converged {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// Ensure CRDT-mode nodes keep accepting adds on both sides of a partition and converge
+// on the total once it heals.
+func TestCRDT_Partition(t *testing.T) {
+	cfg := config{mode: "crdt", gossipInterval: 20 * time.Millisecond}
+	net, ids := newCluster(t, cfg, 5)
+
+	add(t, net, ids[0], 1)
+	waitForValue(t, net, ids, 1, 2*time.Second)
+
+	// Split the cluster and keep adding on both sides.
+	net.Partition(ids[:2], ids[2:])
+
+	want := 1
+	for i := 0; i < 50; i++ {
+		add(t, net, ids[i%len(ids)], i)
+		want += i
+	}
+
+	// Nodes only see the adds made on their side of the partition.
+	time.Sleep(100 * time.Millisecond)
+	if got := read(t, net, ids[0]); got == want {
+		t.Fatalf("%s read the full total %d while partitioned", ids[0], got)
+	}
+
+	net.Heal()
+	waitForValue(t, net, ids, want, 2*time.Second)
+}
+
+// Ensure CRDT mode applies decrements, including ones made on the other side of a partition,
+// as the pn-counter workload requires.
+func TestCRDT_Decrement(t *testing.T) {
+	cfg := config{mode: "crdt", gossipInterval: 20 * time.Millisecond}
+	net, ids := newCluster(t, cfg, 3)
+
+	add(t, net, ids[0], 10)
+	waitForValue(t, net, ids, 10, 2*time.Second)
+
+	net.Partition(ids[:1], ids[1:])
+	add(t, net, ids[0], -4)
+	add(t, net, ids[1], -15)
+	add(t, net, ids[2], 2)
+
+	net.Heal()
+	waitForValue(t, net, ids, -7, 2*time.Second)
+}
+
+// Ensure KV mode sums signed deltas, letting the counter and individual keys go negative.
+func TestKV_SignedDeltas(t *testing.T) {
+	net, ids := newCluster(t, kvConfig("seq", "nonce"), 3)
+
+	add(t, net, ids[0], 5)
+	add(t, net, ids[1], -8)
+	add(t, net, ids[2], 1)
+	add(t, net, ids[0], -1)
+
+	for _, id := range ids {
+		if got, want := read(t, net, id), -3; got != want {
+			t.Fatalf("%s read %d, want %d", id, got, want)
+		}
+	}
+}
+
+// Ensure both modes reject deltas that aren't integers rather than truncating them.
+func TestAdd_MalformedDelta(t *testing.T) {
+	for _, mode := range []string{"kv", "crdt"} {
+		t.Run(mode, func(t *testing.T) {
+			cfg := kvConfig("seq", "stale")
+			cfg.mode, cfg.gossipInterval = mode, 20*time.Millisecond
+			net, ids := newCluster(t, cfg, 1)
+
+			for _, delta := range []any{1.5, "1", nil} {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				_, err := net.Call(ctx, ids[0], map[string]any{"type": "add", "delta": delta})
+				cancel()
+
+				var rpcErr *maelstrom.RPCError
+				if !errors.As(err, &rpcErr) || rpcErr.Code != maelstrom.MalformedRequest {
+					t.Fatalf("add %v: err=%v, want MalformedRequest", delta, err)
+				}
+			}
+		})
+	}
+}
+
+// Ensure a CRDT-mode node rejects the smallest int as a delta, since its negation overflows, and
+// keeps serving afterwards.
+func TestCRDT_OverflowingDelta(t *testing.T) {
+	net, ids := newCluster(t, config{mode: "crdt", gossipInterval: 20 * time.Millisecond}, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := net.Call(ctx, ids[0], map[string]any{"type": "add", "delta": math.MinInt})
+
+	var rpcErr *maelstrom.RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != maelstrom.MalformedRequest {
+		t.Fatalf("add %d: err=%v, want MalformedRequest", math.MinInt, err)
+	}
+
+	add(t, net, ids[0], -1)
+	if got := read(t, net, ids[0]); got != -1 {
+		t.Fatalf("read=%d, want -1", got)
+	}
+}
+
+// Ensure every read-consistency mode but "stale" returns the acknowledged total from every node,
+// even though seq-kv serves each node the oldest state it legally can, and that lin-kv needs no
+// barrier.
+func TestKV_ReadConsistency(t *testing.T) {
+	for _, mode := range []string{"stale", "nonce", "epoch", "lin"} {
+		t.Run(mode, func(t *testing.T) {
+			// "lin" is the deprecated alias for the lin backend, kept for existing scripts.
+			net, ids := newCluster(t, kvConfig("seq", mode), 3)
+
+			// Two rounds of adds, so that every node has written after every other node's
+			// first write and sees all of the keys, if not their latest values.
+			want := 0
+			for i := 0; i < 2*len(ids); i++ {
+				add(t, net, ids[i%len(ids)], i+1)
+				want += i + 1
+			}
+
+			stale := false
+			for _, id := range ids {
+				if got := read(t, net, id); got != want {
+					if mode != "stale" {
+						t.Fatalf("%s read %d, want %d", id, got, want)
+					}
+					stale = true
+				}
+			}
+			if mode == "stale" && !stale {
+				t.Fatal("expected a stale read from seq-kv without a barrier")
+			}
+		})
+	}
+}
+
+// Ensure the deprecated "lin" read consistency is refused where it can't mean the lin backend.
+func TestKV_LegacyLinConflict(t *testing.T) {
+	if _, err := newCounterNode(kvConfig("lww", "lin")); err == nil {
+		t.Fatal("lin read consistency accepted with the lww backend")
+	}
+}
+
+// Ensure intervals that would panic a ticker are refused when the node is created.
+func TestNewCounterNode_Intervals(t *testing.T) {
+	kv := kvConfig("seq", "auto")
+	kv.flushInterval = 0
+	if _, err := newCounterNode(kv); err == nil {
+		t.Error("zero flush interval accepted")
+	}
+
+	for _, d := range []time.Duration{0, -time.Second} {
+		if _, err := newCounterNode(config{mode: "crdt", gossipInterval: d}); err == nil {
+			t.Errorf("gossip interval %v accepted", d)
+		}
+	}
+}
+
+// flakyKV wraps a KV stand-in, counting compare-and-swaps and failing them while broken is set.
+type flakyKV struct {
+	*simnet.KV
+	cas    atomic.Int64
+	broken atomic.Bool
+}
+
+func (f *flakyKV) Handle(src string, body map[string]any) map[string]any {
+	if body["type"] == "cas" {
+		f.cas.Add(1)
+		if f.broken.Load() {
+			return map[string]any{"type": "error", "code": maelstrom.TemporarilyUnavailable, "text": "broken"}
+		}
+	}
+	return f.KV.Handle(src, body)
+}
+
+// Ensure concurrent adds on one node are applied with far fewer compare-and-swaps than adds.
+func TestKV_CoalescedAdds(t *testing.T) {
+	net, ids := newCluster(t, kvConfig("seq", "stale"), 1)
+	kv := &flakyKV{KV: simnet.NewSeqKV()}
+	net.AddService("seq-kv", kv)
+
+	const adds = 100
+	var wg sync.WaitGroup
+	for i := 0; i < adds; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := net.Call(ctx, ids[0], map[string]any{"type": "add", "delta": 1}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := read(t, net, ids[0]); got != adds {
+		t.Fatalf("read %d, want %d", got, adds)
+	}
+	if got := kv.cas.Load(); got >= adds/4 {
+		t.Fatalf("%d adds took %d compare-and-swaps", adds, got)
+	}
+}
+
+// Ensure adds whose flush fails are reported as failed and never applied by a later flush.
+func TestKV_FailedFlush(t *testing.T) {
+	net, ids := newCluster(t, kvConfig("seq", "stale"), 1)
+	kv := &flakyKV{KV: simnet.NewSeqKV()}
+	net.AddService("seq-kv", kv)
+
+	kv.broken.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var rpcErr *maelstrom.RPCError
+	if _, err := net.Call(ctx, ids[0], map[string]any{"type": "add", "delta": 5}); !errors.As(err, &rpcErr) || rpcErr.Code != maelstrom.TemporarilyUnavailable {
+		t.Fatalf("add during failure: err=%v, want TemporarilyUnavailable", err)
+	}
+
+	kv.broken.Store(false)
+	add(t, net, ids[0], 1)
+	if got, want := read(t, net, ids[0]), 1; got != want {
+		t.Fatalf("read %d, want %d", got, want)
+	}
+}
+
+// brokenWrites wraps a KV stand-in, failing its writes while broken is set.
+type brokenWrites struct {
+	simnet.Service
+	broken atomic.Bool
+}
+
+func (b *brokenWrites) Handle(src string, body map[string]any) map[string]any {
+	if body["type"] == "write" && b.broken.Load() {
+		return map[string]any{"type": "error", "code": maelstrom.TemporarilyUnavailable, "text": "broken"}
+	}
+	return b.Service.Handle(src, body)
+}
+
+// Ensure an add on lww-kv isn't acknowledged until a write of its count succeeds, and that its
+// retry is then acknowledged without being applied twice.
+func TestKV_LWWFailedWrite(t *testing.T) {
+	net, ids := newCluster(t, kvConfig("lww", "auto"), 1)
+	kv := &brokenWrites{Service: simnet.NewLWWKV(1)}
+	net.AddService("lww-kv", kv)
+
+	kv.broken.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	body := map[string]any{"type": "add", "delta": 5, "idempotency_key": "a"}
+	var rpcErr *maelstrom.RPCError
+	if _, err := net.Call(ctx, ids[0], body); !errors.As(err, &rpcErr) || rpcErr.Code != maelstrom.TemporarilyUnavailable {
+		t.Fatalf("add during failure: err=%v, want TemporarilyUnavailable", err)
+	}
+
+	kv.broken.Store(false)
+	if _, err := net.Call(ctx, ids[0], body); err != nil {
+		t.Fatalf("retried add: %v", err)
+	}
+	if got, want := read(t, net, ids[0]), 5; got != want {
+		t.Fatalf("read %d, want %d", got, want)
+	}
+}
+
+// Ensure "stats" counts one update and one compare-and-swap per flush when nothing else writes
+// the node's key, even though seq-kv's reads lag behind the other node's writes.
+func TestKV_Stats(t *testing.T) {
+	net, ids := newCluster(t, kvConfig("seq", "stale"), 2)
+
+	for i := 0; i < 4; i++ {
+		add(t, net, ids[i%2], 1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m, err := net.Call(ctx, ids[0], map[string]any{"type": "stats"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body statsOKMessageBody
+	if err := json.Unmarshal(m.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if want := (optimistic.Stats{Updates: 2, Attempts: 2}); body.CAS != want {
+		t.Fatalf("stats=%+v, want %+v", body.CAS, want)
+	}
+}
+
+// Ensure a read counts the keys of nodes that have never written as 0.
+func TestKV_ReadMissingKeys(t *testing.T) {
+	net, ids := newCluster(t, kvConfig("seq", "nonce"), 3)
+
+	if got := read(t, net, ids[0]); got != 0 {
+		t.Fatalf("read %d before any add, want 0", got)
+	}
+
+	add(t, net, ids[1], 4)
+	if got := read(t, net, ids[0]); got != 4 {
+		t.Fatalf("read %d, want 4", got)
+	}
+}
+
+// shardKV wraps a KV stand-in, failing the first failReads reads of every key with
+// TemporarilyUnavailable and never answering reads of the keys in lost.
+type shardKV struct {
+	*simnet.KV
+
+	mu        sync.Mutex
+	lost      map[string]bool
+	failReads int
+	failed    map[string]int
+}
+
+func (s *shardKV) Handle(src string, body map[string]any) map[string]any {
+	if body["type"] == "read" {
+		key, _ := body["key"].(string)
+
+		s.mu.Lock()
+		lost, fail := s.lost[key], s.failed[key] < s.failReads
+		s.failed[key]++
+		s.mu.Unlock()
+
+		if lost {
+			return nil
+		}
+		if fail {
+			return map[string]any{"type": "error", "code": maelstrom.TemporarilyUnavailable, "text": "try again"}
+		}
+	}
+	return s.KV.Handle(src, body)
+}
+
+// Ensure reads retry transient errors, and answer TemporarilyUnavailable, within the request
+// timeout, when a key can't be read at all.
+func TestKV_ReadFailures(t *testing.T) {
+	cfg := kvConfig("seq", "stale")
+	cfg.requestTimeout = 200 * time.Millisecond
+	net, ids := newCluster(t, cfg, 3)
+
+	kv := &shardKV{KV: simnet.NewSeqKV(), failed: make(map[string]int)}
+	net.AddService("seq-kv", kv)
+
+	add(t, net, ids[0], 7)
+
+	kv.mu.Lock()
+	kv.failReads = 2
+	kv.mu.Unlock()
+	if got := read(t, net, ids[0]); got != 7 {
+		t.Fatalf("read %d, want 7", got)
+	}
+
+	kv.mu.Lock()
+	kv.lost = map[string]bool{"counter-n2": true}
+	kv.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	var rpcErr *maelstrom.RPCError
+	if _, err := net.Call(ctx, ids[0], map[string]any{"type": "read"}); !errors.As(err, &rpcErr) || rpcErr.Code != maelstrom.TemporarilyUnavailable {
+		t.Fatalf("read with a lost shard: err=%v, want TemporarilyUnavailable", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("read took %v to fail", elapsed)
+	}
+}
+
+// Ensure a read of a 20-node cluster takes about one KV round trip rather than one per node.
+func TestKV_ReadLatency(t *testing.T) {
+	const latency = 10 * time.Millisecond
+
+	net, ids := newCluster(t, kvConfig("seq", "stale"), 20)
+	net.SetLatency(latency)
+
+	start := time.Now()
+	read(t, net, ids[0])
+
+	// Reading the keys one at a time would take 20 round trips, or 400ms.
+	if elapsed := time.Since(start); elapsed > 5*2*latency {
+		t.Fatalf("a read took %v with %v round trips", elapsed, 2*latency)
+	}
+}
+
+/*
+BenchmarkKV_Read measures the latency of a KV-mode read in each read-consistency mode on seq-kv,
+and on lin-kv, on a 3-node cluster whose messages take 1ms each way. A plain read costs one KV
+round trip.
+*/
+func BenchmarkKV_Read(b *testing.B) {
+	for _, mode := range []string{"stale", "nonce", "epoch", "lin"} {
+		cfg := kvConfig("seq", mode)
+		if mode == "lin" {
+			cfg = kvConfig("lin", "stale")
+		}
+
+		b.Run(mode, func(b *testing.B) {
+			net := simnet.New()
+			net.AddService("seq-kv", simnet.NewSeqKV())
+			net.AddService("lin-kv", simnet.NewKV())
+			defer net.Close()
+
+			ids := []string{"n0", "n1", "n2"}
+			for _, id := range ids {
+				n, err := newCounterNode(cfg)
+				if err != nil {
+					b.Fatal(err)
+				}
+				net.AddNode(id, n)
+			}
+
+			ctx := context.Background()
+			if err := net.Init(ctx, ids); err != nil {
+				b.Fatal(err)
+			}
+			// Every node must have written after every other node's first write, or a stale
+			// read misses some of the keys.
+			for i := 0; i < 2*len(ids); i++ {
+				if _, err := net.Call(ctx, ids[i%len(ids)], map[string]any{"type": "add", "delta": 1}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			net.SetLatency(time.Millisecond)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := net.Call(ctx, ids[i%len(ids)], map[string]any{"type": "read"}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"maelstrom-counter/simnet"
+)
+
+/*
+TestBackendMatrix runs a g-counter workload against every combination of KV backend and read
+consistency, using the stand-ins from newCluster, and reports which combinations keep the
+counter correct: every node's final read must return the sum of the acknowledged adds.
+
+Every combination a backend selects with "auto" must be correct. seq-kv without a barrier is
+expected to fail, since its stand-in serves each node the oldest state it legally can.
+
+The last row keeps the counter in the compare-and-swap layout used on lin-kv and seq-kv, but on
+the lww-kv stand-in. It is expected to fail: a compare-and-swap runs against one replica, and a
+later one that ran against another replica, from an older count, can win the merge and lose
+acknowledged adds. That is why the lww backend uses ownedCounter instead.
+*/
+func TestBackendMatrix(t *testing.T) {
+	combinations := []struct {
+		backend, readConsistency string
+		casOnLWW                 bool // Serve the backend's service with the lww-kv stand-in.
+		correct                  bool
+	}{
+		{"lin", "auto", false, true},
+		{"lin", "stale", false, true},
+		{"lin", "nonce", false, true},
+		{"lin", "epoch", false, true},
+		{"seq", "auto", false, true},
+		{"seq", "stale", false, false},
+		{"seq", "nonce", false, true},
+		{"seq", "epoch", false, true},
+		{"lww", "auto", false, true},
+		{"lww", "stale", false, true},
+		{"lww", "nonce", false, true},
+		{"lww", "epoch", false, true},
+		{"lin", "stale", true, false},
+	}
+
+	var (
+		mu     sync.Mutex
+		report []string
+	)
+	for _, c := range combinations {
+		name := c.backend + "/" + c.readConsistency
+		var services map[string]simnet.Service
+		if c.casOnLWW {
+			name = "cas-on-lww"
+			services = map[string]simnet.Service{c.backend + "-kv": simnet.NewLWWKV(1)}
+		}
+		t.Run(name, func(t *testing.T) {
+			correct, detail := runGCounter(t, kvConfig(c.backend, c.readConsistency), services)
+
+			mu.Lock()
+			report = append(report, fmt.Sprintf("%-12s correct=%-5v %s", name, correct, detail))
+			mu.Unlock()
+
+			if correct != c.correct {
+				t.Errorf("correct=%v, want %v: %s", correct, c.correct, detail)
+			}
+		})
+	}
+
+	t.Logf("g-counter correctness by backend/read-consistency:\n%s", strings.Join(report, "\n"))
+}
+
+/*
+runGCounter runs a g-counter workload on a fresh 3-node cluster with cfg, whose stand-ins are
+replaced by services, keyed by name: 60 concurrent adds spread over all of the nodes and over
+120ms, so that they take several flushes, followed by reads from every node until they all
+return the total of the acknowledged adds or a second has passed.
+
+Returns:
+  - Whether every node's final read returned the total.
+  - A description of the final reads.
+*/
+func runGCounter(t *testing.T, cfg config, services map[string]simnet.Service) (bool, string) {
+	t.Helper()
+
+	net, ids := newCluster(t, cfg, 3)
+	for name, svc := range services {
+		net.AddService(name, svc)
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		total int
+	)
+	for i := 0; i < 60; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			time.Sleep(time.Duration(i) * 2 * time.Millisecond)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			delta := i%5 + 1
+			if _, err := net.Call(ctx, ids[i%len(ids)], map[string]any{"type": "add", "delta": delta}); err != nil {
+				t.Errorf("add %d: %v", delta, err)
+				return
+			}
+
+			mu.Lock()
+			total += delta
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		reads := make([]int, len(ids))
+		converged := true
+		for i, id := range ids {
+			reads[i] = read(t, net, id)
+			converged = converged && reads[i] == total
+		}
+
+		if converged || time.Now().After(deadline) {
+			return converged, fmt.Sprintf("reads=%v total=%d", reads, total)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
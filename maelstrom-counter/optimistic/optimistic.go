@@ -0,0 +1,180 @@
+package optimistic
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Policy bounds how long an update keeps retrying after losing a compare-and-swap race.
+type Policy struct {
+	MaxAttempts int           // Compare-and-swaps tried before giving up.
+	BaseDelay   time.Duration // Upper bound of the first backoff; it doubles after every conflict.
+	MaxDelay    time.Duration // Largest upper bound of a backoff.
+}
+
+// DefaultPolicy gives up after 20 conflicts in a row. The backoffs between them average about 0.36s in total.
+var DefaultPolicy = Policy{
+	MaxAttempts: 20,
+	BaseDelay:   time.Millisecond,
+	MaxDelay:    50 * time.Millisecond,
+}
+
+// Backoff sleeps for a random duration of up to BaseDelay*2^(attempt-1), capped at MaxDelay,
+// or until ctx expires, in which case it returns ctx's error.
+func (p Policy) Backoff(ctx context.Context, attempt int) error {
+	bound := p.MaxDelay
+	if attempt-1 < 32 && p.BaseDelay<<(attempt-1) < bound {
+		bound = p.BaseDelay << (attempt - 1)
+	}
+	if bound <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(bound) + 1)))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Stats is a snapshot of an Updater's metrics.
+type Stats struct {
+	Updates    int64 `json:"updates"`     // Calls to Update.
+	Attempts   int64 `json:"attempts"`    // Compare-and-swaps sent.
+	Retries    int64 `json:"retries"`     // Compare-and-swaps that failed their precondition and were retried or given up on.
+	MaxRetries int64 `json:"max_retries"` // Most retries made by a single update.
+	Exhausted  int64 `json:"exhausted"`   // Updates that gave up after Policy.MaxAttempts.
+	TimedOut   int64 `json:"timed_out"`   // Updates whose context expired first.
+}
+
+// Updater performs read-modify-write updates on the keys of a KV store using compare-and-swap.
+// An update that loses a race backs off for a random delay, with exponentially growing bounds,
+// and retries until it succeeds, its context expires or it runs out of attempts.
+// It is safe for concurrent use.
+type Updater struct {
+	kv     *maelstrom.KV
+	policy Policy
+
+	updates    atomic.Int64
+	attempts   atomic.Int64
+	retries    atomic.Int64
+	maxRetries atomic.Int64
+	exhausted  atomic.Int64
+	timedOut   atomic.Int64
+}
+
+// NewUpdater initializes and returns a pointer to a new Updater for kv.
+func NewUpdater(kv *maelstrom.KV, policy Policy) *Updater {
+	return &Updater{
+		kv:     kv,
+		policy: policy,
+	}
+}
+
+// Stats returns a snapshot of the updater's metrics.
+func (u *Updater) Stats() Stats {
+	return Stats{
+		Updates:    u.updates.Load(),
+		Attempts:   u.attempts.Load(),
+		Retries:    u.retries.Load(),
+		MaxRetries: u.maxRetries.Load(),
+		Exhausted:  u.exhausted.Load(),
+		TimedOut:   u.timedOut.Load(),
+	}
+}
+
+/*
+Update replaces the value of key with fn applied to it.
+
+Parameters:
+  - ctx: bounds the whole update, retries included.
+  - u: the updater to run the update with.
+  - key: the key to update. A missing key is created.
+  - fn: computes the new value from the current one; exists is false, and cur the zero value,
+    if the key doesn't exist. It may be called once per attempt, and an error from it is
+    returned as is.
+
+Returns:
+  - nil once a compare-and-swap from the value fn was given succeeds.
+  - An *maelstrom.RPCError with code TemporarilyUnavailable if every attempt lost a race. The
+    update definitely didn't happen.
+  - An *maelstrom.RPCError with code Crash if ctx expired first. The update may have happened,
+    and Crash, like Timeout, tells the client so; Timeout itself can't be used, since its code, 0,
+    reads as success to maelstrom.Message.RPCError.
+  - Any other error from the store.
+*/
+func Update[T any](ctx context.Context, u *Updater, key string, fn func(cur T, exists bool) (T, error)) error {
+	u.updates.Add(1)
+
+	for attempt := 1; ; attempt++ {
+		var cur T
+		exists := true
+		if err := u.kv.ReadInto(ctx, key, &cur); isCode(err, maelstrom.KeyDoesNotExist) {
+			exists = false
+		} else if err != nil {
+			return u.fail(ctx, err)
+		}
+
+		next, err := fn(cur, exists)
+		if err != nil {
+			return err
+		}
+
+		u.attempts.Add(1)
+		err = u.kv.CompareAndSwap(ctx, key, cur, next, true)
+		if err == nil {
+			u.recordRetries(int64(attempt - 1))
+			return nil
+		}
+		if !isCode(err, maelstrom.PreconditionFailed) {
+			return u.fail(ctx, err)
+		}
+
+		u.retries.Add(1)
+		if attempt >= u.policy.MaxAttempts {
+			u.recordRetries(int64(attempt))
+			u.exhausted.Add(1)
+			return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable,
+				fmt.Sprintf("update of %s lost %d compare-and-swap races", key, attempt))
+		}
+
+		if err := u.policy.Backoff(ctx, attempt); err != nil {
+			return u.fail(ctx, err)
+		}
+	}
+}
+
+// fail converts err into the error returned by Update, recording a timeout if ctx has expired.
+func (u *Updater) fail(ctx context.Context, err error) error {
+	if ctx.Err() == nil {
+		return err
+	}
+
+	u.timedOut.Add(1)
+	return maelstrom.NewRPCError(maelstrom.Crash, fmt.Sprintf("timed out: %v", err))
+}
+
+// recordRetries records the number of retries made by an update that has finished.
+func (u *Updater) recordRetries(n int64) {
+	for {
+		max := u.maxRetries.Load()
+		if n <= max || u.maxRetries.CompareAndSwap(max, n) {
+			return
+		}
+	}
+}
+
+// isCode reports whether err is an *maelstrom.RPCError with the given code.
+func isCode(err error, code int) bool {
+	rpcErr, ok := err.(*maelstrom.RPCError)
+	return ok && rpcErr.Code == code
+}
@@ -0,0 +1,195 @@
+package optimistic_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"maelstrom-counter/optimistic"
+	"maelstrom-counter/simnet"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestMain(m *testing.M) {
+	// maelstrom.Node logs every message it sends and receives.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// conflictKV is a KV stand-in whose compare-and-swaps always fail their precondition.
+type conflictKV struct {
+	*simnet.KV
+}
+
+func (c conflictKV) Handle(src string, body map[string]any) map[string]any {
+	if body["type"] == "cas" {
+		return map[string]any{"type": "error", "code": maelstrom.PreconditionFailed, "text": "conflict"}
+	}
+	return c.KV.Handle(src, body)
+}
+
+/*
+newCluster starts count nodes that increment the shared key "k" in lin-kv with optimistic.Update
+whenever they receive an "incr" message, answering with "incr_ok" or the update's error.
+
+Returns:
+  - The network, with kv serving lin-kv.
+  - The node IDs.
+  - The nodes' updaters, in the same order.
+*/
+func newCluster(t *testing.T, kv simnet.Service, count int, policy optimistic.Policy, timeout time.Duration) (*simnet.Network, []string, []*optimistic.Updater) {
+	t.Helper()
+
+	net := simnet.New()
+	net.AddService("lin-kv", kv)
+	t.Cleanup(net.Close)
+
+	ids := make([]string, count)
+	updaters := make([]*optimistic.Updater, count)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("n%d", i)
+
+		n := maelstrom.NewNode()
+		u := optimistic.NewUpdater(maelstrom.NewLinKV(n), policy)
+		n.Handle("incr", func(msg maelstrom.Message) error {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			err := optimistic.Update(ctx, u, "k", func(cur int, _ bool) (int, error) {
+				return cur + 1, nil
+			})
+			if err != nil {
+				return err
+			}
+			return n.Reply(msg, map[string]any{"type": "incr_ok"})
+		})
+
+		net.AddNode(ids[i], n)
+		updaters[i] = u
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := net.Init(ctx, ids); err != nil {
+		t.Fatal(err)
+	}
+
+	return net, ids, updaters
+}
+
+// incr sends an "incr" request to node id and returns the error reply, if any.
+func incr(net *simnet.Network, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := net.Call(ctx, id, map[string]any{"type": "incr"})
+	return err
+}
+
+// Ensure concurrent updates of one key from many nodes are each applied exactly once, and that
+// the updates that lost too many races report it rather than spinning.
+func TestUpdate_Contention(t *testing.T) {
+	kv := simnet.NewKV()
+	policy := optimistic.Policy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: 4 * time.Millisecond}
+	net, ids, updaters := newCluster(t, kv, 5, policy, 5*time.Second)
+	net.SetLatency(time.Millisecond)
+
+	const perNode = 20
+	var (
+		wg          sync.WaitGroup
+		mu          sync.Mutex
+		ok, gaveUp  int
+		unexpecteds []error
+	)
+	for _, id := range ids {
+		for i := 0; i < perNode; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				err := incr(net, id)
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				var rpcErr *maelstrom.RPCError
+				switch {
+				case err == nil:
+					ok++
+				case errors.As(err, &rpcErr) && rpcErr.Code == maelstrom.TemporarilyUnavailable:
+					gaveUp++
+				default:
+					unexpecteds = append(unexpecteds, err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	if len(unexpecteds) > 0 {
+		t.Fatalf("unexpected errors: %v", unexpecteds)
+	}
+
+	reply := kv.Handle("c1", map[string]any{"type": "read", "key": "k"})
+	if got, _ := reply["value"].(float64); int(got) != ok {
+		t.Fatalf("k=%v after %d successful updates", reply["value"], ok)
+	}
+
+	var total optimistic.Stats
+	for _, u := range updaters {
+		s := u.Stats()
+		total.Updates += s.Updates
+		total.Retries += s.Retries
+		total.Exhausted += s.Exhausted
+		if s.MaxRetries > int64(policy.MaxAttempts) {
+			t.Errorf("an update retried %d times, more than MaxAttempts", s.MaxRetries)
+		}
+	}
+	if total.Updates != int64(len(ids)*perNode) || total.Exhausted != int64(gaveUp) {
+		t.Fatalf("stats=%+v, want %d updates and %d exhausted", total, len(ids)*perNode, gaveUp)
+	}
+	if total.Retries == 0 {
+		t.Fatal("expected contention to cause retries")
+	}
+}
+
+// Ensure an update that keeps losing races gives up after MaxAttempts with TemporarilyUnavailable.
+func TestUpdate_MaxAttempts(t *testing.T) {
+	policy := optimistic.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	net, ids, updaters := newCluster(t, conflictKV{simnet.NewKV()}, 1, policy, 5*time.Second)
+
+	var rpcErr *maelstrom.RPCError
+	if err := incr(net, ids[0]); !errors.As(err, &rpcErr) || rpcErr.Code != maelstrom.TemporarilyUnavailable {
+		t.Fatalf("err=%v, want TemporarilyUnavailable", err)
+	}
+
+	want := optimistic.Stats{Updates: 1, Attempts: 3, Retries: 3, MaxRetries: 3, Exhausted: 1}
+	if got := updaters[0].Stats(); got != want {
+		t.Fatalf("stats=%+v, want %+v", got, want)
+	}
+}
+
+// Ensure an update gives up with Crash, an indefinite error, once its context expires,
+// even if it still has attempts left.
+func TestUpdate_Deadline(t *testing.T) {
+	policy := optimistic.Policy{MaxAttempts: 1000, BaseDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	net, ids, updaters := newCluster(t, conflictKV{simnet.NewKV()}, 1, policy, 50*time.Millisecond)
+
+	start := time.Now()
+	var rpcErr *maelstrom.RPCError
+	if err := incr(net, ids[0]); !errors.As(err, &rpcErr) || rpcErr.Code != maelstrom.Crash {
+		t.Fatalf("err=%v, want Crash", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("update took %v to give up", elapsed)
+	}
+	if got := updaters[0].Stats().TimedOut; got != 1 {
+		t.Fatalf("timed out=%d, want 1", got)
+	}
+}
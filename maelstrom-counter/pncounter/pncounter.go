@@ -0,0 +1,77 @@
+package pncounter
+
+import (
+	"math"
+	"sync"
+
+	"maelstrom-counter/gcounter"
+)
+
+// State is the replicated state of a PNCounter, as sent to other replicas.
+type State struct {
+	Inc map[string]int `json:"inc"` // Per-node totals of positive deltas.
+	Dec map[string]int `json:"dec"` // Per-node totals of negated negative deltas.
+}
+
+// PNCounter is a state-based counter CRDT supporting increments and decrements. It pairs two
+// G-counters: one accumulating increments and one accumulating decrements. Each half only grows,
+// so the pair merges exactly like a G-counter, and the counter's value is their difference.
+// It is safe for concurrent use.
+type PNCounter struct {
+	mu  sync.RWMutex // mu is held for writing while either half changes, so reads see both halves at once.
+	inc *gcounter.GCounter
+	dec *gcounter.GCounter
+}
+
+// New initializes and returns a pointer to a new PNCounter with a value of 0.
+func New() *PNCounter {
+	return &PNCounter{
+		inc: gcounter.New(),
+		dec: gcounter.New(),
+	}
+}
+
+// Add adds delta, which may be negative, to node's entry. It returns gcounter.ErrOverflow if
+// delta is math.MinInt, which can't be negated, or if node's increments or decrements would
+// overflow; the counter is then unchanged.
+func (p *PNCounter) Add(node string, delta int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case delta == math.MinInt:
+		return gcounter.ErrOverflow
+	case delta < 0:
+		return p.dec.Add(node, -delta)
+	default:
+		return p.inc.Add(node, delta)
+	}
+}
+
+// Merge folds another replica's state into this one.
+func (p *PNCounter) Merge(s State) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.inc.Merge(s.Inc)
+	p.dec.Merge(s.Dec)
+}
+
+// State returns a copy of the counter's state, suitable for sending to other replicas.
+func (p *PNCounter) State() State {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return State{
+		Inc: p.inc.State(),
+		Dec: p.dec.State(),
+	}
+}
+
+// Value returns the current value of the counter: the sum of all increments minus the sum of all decrements.
+func (p *PNCounter) Value() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.inc.Value() - p.dec.Value()
+}
@@ -0,0 +1,98 @@
+package pncounter_test
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"maelstrom-counter/gcounter"
+	"maelstrom-counter/pncounter"
+)
+
+func TestPNCounter_Add(t *testing.T) {
+	p := pncounter.New()
+	p.Add("n1", 5)
+	p.Add("n1", -7)
+	p.Add("n2", -1)
+	p.Add("n2", 0)
+
+	if got, want := p.Value(), -3; got != want {
+		t.Fatalf("value=%d, want %d", got, want)
+	}
+
+	want := pncounter.State{
+		Inc: map[string]int{"n1": 5, "n2": 0},
+		Dec: map[string]int{"n1": 7, "n2": 1},
+	}
+	if got := p.State(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("state=%v, want %v", got, want)
+	}
+}
+
+// Ensure a decrement that a replica has already seen is never undone by a stale state,
+// and that replicas converge regardless of merge order.
+func TestPNCounter_Merge(t *testing.T) {
+	a, b := pncounter.New(), pncounter.New()
+	a.Add("n1", 10)
+	stale := a.State()
+	a.Add("n1", -4)
+	b.Add("n2", -6)
+
+	ab := pncounter.New()
+	ab.Merge(a.State())
+	ab.Merge(b.State())
+	ab.Merge(stale)
+
+	ba := pncounter.New()
+	ba.Merge(stale)
+	ba.Merge(b.State())
+	ba.Merge(a.State())
+
+	if !reflect.DeepEqual(ab.State(), ba.State()) {
+		t.Fatalf("merge order matters: %v != %v", ab.State(), ba.State())
+	}
+	if got, want := ab.Value(), 0; got != want {
+		t.Fatalf("value=%d, want %d", got, want)
+	}
+}
+
+func TestPNCounter_AddMinInt(t *testing.T) {
+	p := pncounter.New()
+	if err := p.Add("n1", math.MinInt); !errors.Is(err, gcounter.ErrOverflow) {
+		t.Fatalf("add MinInt: err=%v, want ErrOverflow", err)
+	}
+	if got := p.Value(); got != 0 {
+		t.Fatalf("value=%d after a failed add, want 0", got)
+	}
+}
+
+// Ensure reads never see an increment without the decrement added with it; run with -race.
+func TestPNCounter_ConsistentValue(t *testing.T) {
+	p := pncounter.New()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// Applied as one state, so the value is always 0.
+			s := p.State()
+			s.Inc["n1"]++
+			s.Dec["n1"]++
+			p.Merge(s)
+		}
+	}()
+
+	for i := 0; i < 10000; i++ {
+		if got := p.Value(); got != 0 {
+			t.Fatalf("value=%d, want 0", got)
+		}
+	}
+	close(stop)
+	<-done
+}
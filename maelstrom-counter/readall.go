@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"maelstrom-counter/optimistic"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+/*
+sumKeys reads every counter key concurrently and returns the sum of their counts.
+
+Parameters:
+  - ctx: bounds the whole read, retries included.
+  - kv: the key-value store to read from.
+  - keys: the counter keys to read. A key that doesn't exist counts as 0.
+  - policy: how often, and how far apart, a read that fails transiently is retried.
+
+Returns:
+  - The sum of the counts.
+  - An error as for readKeys.
+*/
+func sumKeys(ctx context.Context, kv *maelstrom.KV, keys []string, policy optimistic.Policy) (int, error) {
+	vals, err := readKeys(ctx, kv, keys, policy)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, v := range vals {
+		total += v.Count
+	}
+	return total, nil
+}
+
+/*
+readKeys reads every counter key concurrently.
+
+Parameters:
+  - ctx: bounds the whole read, retries included.
+  - kv: the key-value store to read from.
+  - keys: the counter keys to read.
+  - policy: how often, and how far apart, a read that fails transiently is retried.
+
+Returns:
+  - The value of each key, in the same order; the zero counterValue for a key that doesn't exist.
+  - An *maelstrom.RPCError with code TemporarilyUnavailable if any key couldn't be read before
+    ctx expired or its retries ran out. Reads have no side effects, so the client may retry.
+*/
+func readKeys(ctx context.Context, kv *maelstrom.KV, keys []string, policy optimistic.Policy) ([]counterValue, error) {
+	vals := make([]counterValue, len(keys))
+	errs := make([]error, len(keys))
+
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vals[i], errs[i] = readKey(ctx, kv, key, policy)
+		}()
+	}
+	wg.Wait()
+
+	for i, key := range keys {
+		if errs[i] != nil {
+			return nil, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable,
+				fmt.Sprintf("reading %s: %v", key, errs[i]))
+		}
+	}
+	return vals, nil
+}
+
+// readKey reads a counter key, treating a missing key as the zero value and retrying transient errors.
+func readKey(ctx context.Context, kv *maelstrom.KV, key string, policy optimistic.Policy) (counterValue, error) {
+	for attempt := 1; ; attempt++ {
+		var val counterValue
+		err := kv.ReadInto(ctx, key, &val)
+		if rpcErr, ok := err.(*maelstrom.RPCError); ok && rpcErr.Code == maelstrom.KeyDoesNotExist {
+			return counterValue{}, nil
+		} else if err == nil || !transient(err) || attempt >= policy.MaxAttempts {
+			return val, err
+		}
+
+		if err := policy.Backoff(ctx, attempt); err != nil {
+			return counterValue{}, err
+		}
+	}
+}
+
+// transient reports whether a KV request that failed with err may succeed if retried.
+func transient(err error) bool {
+	rpcErr, ok := err.(*maelstrom.RPCError)
+	if !ok {
+		return false // A context error, or one we can't interpret.
+	}
+
+	switch rpcErr.Code {
+	case maelstrom.TemporarilyUnavailable, maelstrom.Crash, maelstrom.Abort, maelstrom.TxnConflict:
+		return true
+	default:
+		return false
+	}
+}
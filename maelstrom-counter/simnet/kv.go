@@ -0,0 +1,223 @@
+package simnet
+
+import (
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// KV is an in-process stand-in for Maelstrom's key-value services. Writes are applied atomically
+// in a single order. NewKV returns a store whose reads always see the latest write, like lin-kv;
+// NewSeqKV returns one whose reads are as stale as sequential consistency allows, like seq-kv at
+// its worst.
+type KV struct {
+	mu    sync.Mutex
+	stale bool
+
+	history map[string][]version // Every value each key has held, oldest first.
+	latest  int                  // Number of writes applied so far.
+	seen    map[string]int       // The latest write each client has observed, by client ID.
+}
+
+// version is a value written to a key, tagged with the position of its write in the total order.
+type version struct {
+	at    int
+	value any
+}
+
+// NewKV returns a new, empty linearizable KV.
+func NewKV() *KV {
+	return newKV(false)
+}
+
+/*
+NewSeqKV returns a new, empty sequentially consistent KV.
+
+Each client only ever observes the state as of its own most recent write or compare-and-swap,
+so a client that only reads never sees another client's writes. This is legal under sequential
+consistency, and surfaces code that assumes seq-kv reads are fresh.
+*/
+func NewSeqKV() *KV {
+	return newKV(true)
+}
+
+func newKV(stale bool) *KV {
+	return &KV{
+		stale:   stale,
+		history: make(map[string][]version),
+		seen:    make(map[string]int),
+	}
+}
+
+// Handle implements Service for the "read", "write" and "cas" operations.
+func (kv *KV) Handle(src string, body map[string]any) map[string]any {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	key, _ := body["key"].(string)
+
+	switch body["type"] {
+	case "read":
+		at := kv.latest
+		if kv.stale {
+			at = kv.seen[src]
+		}
+
+		v, ok := kv.get(key, at)
+		if !ok {
+			return errorBody(maelstrom.KeyDoesNotExist, "key does not exist")
+		}
+		return map[string]any{"type": "read_ok", "value": v}
+
+	case "write":
+		kv.put(src, key, body["value"])
+		return map[string]any{"type": "write_ok"}
+
+	case "cas":
+		// Compare-and-swap always runs against the latest state, which the client then observes.
+		kv.seen[src] = kv.latest
+
+		v, ok := kv.get(key, kv.latest)
+		if !ok {
+			if create, _ := body["create_if_not_exists"].(bool); !create {
+				return errorBody(maelstrom.KeyDoesNotExist, "key does not exist")
+			}
+		} else if !reflect.DeepEqual(v, body["from"]) {
+			return errorBody(maelstrom.PreconditionFailed, "current value does not match from")
+		}
+		kv.put(src, key, body["to"])
+		return map[string]any{"type": "cas_ok"}
+
+	default:
+		return errorBody(maelstrom.NotSupported, "unsupported operation")
+	}
+}
+
+// get returns the value key held after the first at writes.
+func (kv *KV) get(key string, at int) (any, bool) {
+	versions := kv.history[key]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].at <= at {
+			return versions[i].value, true
+		}
+	}
+	return nil, false
+}
+
+// put appends a write of value to key by client src to the total order.
+func (kv *KV) put(src, key string, value any) {
+	kv.latest++
+	kv.history[key] = append(kv.history[key], version{at: kv.latest, value: value})
+	kv.seen[src] = kv.latest
+}
+
+// errorBody returns the body of an "error" reply.
+func errorBody(code int, text string) map[string]any {
+	return map[string]any{"type": "error", "code": code, "text": text}
+}
+
+// LWWKV is an in-process stand-in for Maelstrom's lww-kv service. Like the real service it
+// simulates several independent replicas: each request is served by a random one, and each
+// write is stamped with that replica's clock, which is skewed by up to a few milliseconds.
+// The first request at least lwwMergeInterval after the last merge first merges the replicas'
+// states, keeping the value with the highest timestamp for each key. Reads may therefore be
+// stale, and a compare-and-swap that succeeds on one replica may be overwritten by a write that
+// another replica accepted.
+type LWWKV struct {
+	mu        sync.Mutex
+	rng       *rand.Rand
+	replicas  []map[string]stamped
+	skew      []time.Duration
+	lastMerge time.Time
+}
+
+// stamped is a value written to an LWWKV replica, with the replica's timestamp.
+type stamped struct {
+	value any
+	ts    time.Time
+}
+
+const (
+	lwwReplicas      = 5                     // Maelstrom's default.
+	lwwMaxSkew       = 5 * time.Millisecond  // Largest clock skew of a replica, either way.
+	lwwMergeInterval = 20 * time.Millisecond // How often replicas merge.
+)
+
+// NewLWWKV returns a new, empty LWWKV whose replicas' clocks are skewed using seed.
+func NewLWWKV(seed int64) *LWWKV {
+	kv := &LWWKV{
+		rng:       rand.New(rand.NewSource(seed)),
+		replicas:  make([]map[string]stamped, lwwReplicas),
+		skew:      make([]time.Duration, lwwReplicas),
+		lastMerge: time.Now(),
+	}
+	for i := range kv.replicas {
+		kv.replicas[i] = make(map[string]stamped)
+		kv.skew[i] = time.Duration(kv.rng.Int63n(int64(2*lwwMaxSkew))) - lwwMaxSkew
+	}
+	return kv
+}
+
+// Handle implements Service for the "read", "write" and "cas" operations.
+func (kv *LWWKV) Handle(src string, body map[string]any) map[string]any {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if time.Since(kv.lastMerge) >= lwwMergeInterval {
+		kv.merge()
+	}
+
+	i := kv.rng.Intn(len(kv.replicas))
+	replica, now := kv.replicas[i], time.Now().Add(kv.skew[i])
+	key, _ := body["key"].(string)
+
+	switch body["type"] {
+	case "read":
+		v, ok := replica[key]
+		if !ok {
+			return errorBody(maelstrom.KeyDoesNotExist, "key does not exist")
+		}
+		return map[string]any{"type": "read_ok", "value": v.value}
+
+	case "write":
+		replica[key] = stamped{value: body["value"], ts: now}
+		return map[string]any{"type": "write_ok"}
+
+	case "cas":
+		v, ok := replica[key]
+		if !ok {
+			if create, _ := body["create_if_not_exists"].(bool); !create {
+				return errorBody(maelstrom.KeyDoesNotExist, "key does not exist")
+			}
+		} else if !reflect.DeepEqual(v.value, body["from"]) {
+			return errorBody(maelstrom.PreconditionFailed, "current value does not match from")
+		}
+		replica[key] = stamped{value: body["to"], ts: now}
+		return map[string]any{"type": "cas_ok"}
+
+	default:
+		return errorBody(maelstrom.NotSupported, "unsupported operation")
+	}
+}
+
+// merge gives every replica, for each key, the value with the highest timestamp of any replica.
+func (kv *LWWKV) merge() {
+	merged := make(map[string]stamped)
+	for _, replica := range kv.replicas {
+		for key, v := range replica {
+			if v.ts.After(merged[key].ts) {
+				merged[key] = v
+			}
+		}
+	}
+
+	for _, replica := range kv.replicas {
+		for key, v := range merged {
+			replica[key] = v
+		}
+	}
+	kv.lastMerge = time.Now()
+}
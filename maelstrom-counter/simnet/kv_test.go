@@ -0,0 +1,38 @@
+package simnet_test
+
+import (
+	"testing"
+
+	"maelstrom-counter/simnet"
+)
+
+// Ensure the seq-kv stand-in serves each client the state as of its own last write, and the
+// lin-kv stand-in always serves the latest state.
+func TestKV_Staleness(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		kv   *simnet.KV
+		want any
+	}{
+		{"lin", simnet.NewKV(), float64(2)},
+		{"seq", simnet.NewSeqKV(), float64(1)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.kv.Handle("n1", map[string]any{"type": "write", "key": "k", "value": float64(1)})
+			tt.kv.Handle("n2", map[string]any{"type": "write", "key": "k", "value": float64(2)})
+
+			if got := tt.kv.Handle("n1", map[string]any{"type": "read", "key": "k"})["value"]; got != tt.want {
+				t.Fatalf("read=%v, want %v", got, tt.want)
+			}
+
+			// A compare-and-swap always sees the latest state, and so do reads after it.
+			reply := tt.kv.Handle("n1", map[string]any{"type": "cas", "key": "k", "from": float64(1), "to": float64(3)})
+			if reply["type"] != "error" {
+				t.Fatalf("cas from a stale value succeeded: %v", reply)
+			}
+			if got := tt.kv.Handle("n1", map[string]any{"type": "read", "key": "k"})["value"]; got != float64(2) {
+				t.Fatalf("read after cas=%v, want 2", got)
+			}
+		})
+	}
+}
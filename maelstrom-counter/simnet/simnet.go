@@ -0,0 +1,335 @@
+package simnet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// ClientID is the source ID used for requests sent with Network.Call.
+const ClientID = "c1"
+
+// Service is an in-process stand-in for one of Maelstrom's built-in services, such as seq-kv.
+type Service interface {
+	// Handle processes a request body sent by node src and returns the reply body.
+	// The reply's "in_reply_to" field is filled in by the network. A nil reply is dropped,
+	// as if it had been lost.
+	Handle(src string, body map[string]any) map[string]any
+}
+
+// Network is an in-process Maelstrom network. It connects maelstrom.Nodes to each other,
+// to stand-in services and to a test client, and can drop traffic between nodes to simulate
+// network partitions. It lets node programs be tested without the Maelstrom binary.
+type Network struct {
+	mu       sync.Mutex
+	nodes    map[string]*endpoint
+	services map[string]Service
+	blocked  map[[2]string]bool
+	pending  map[int]chan maelstrom.Message // Client requests waiting for a reply, by msg_id.
+	latency  time.Duration                  // Delay added to every message between nodes and services.
+
+	nextMsgID atomic.Int64
+}
+
+// endpoint is a node attached to the network.
+type endpoint struct {
+	node  *maelstrom.Node
+	inbox *queue // Lines waiting to be written to the node's stdin.
+}
+
+// New returns a new, empty Network.
+func New() *Network {
+	return &Network{
+		nodes:    make(map[string]*endpoint),
+		services: make(map[string]Service),
+		blocked:  make(map[[2]string]bool),
+		pending:  make(map[int]chan maelstrom.Message),
+	}
+}
+
+// AddService registers svc under name, e.g. "seq-kv". Services are never partitioned.
+func (net *Network) AddService(name string, svc Service) {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	net.services[name] = svc
+}
+
+// AddNode attaches n to the network as id and starts running it. Handlers must already be
+// registered on n. The node is not initialized until Init is called.
+func (net *Network) AddNode(id string, n *maelstrom.Node) {
+	r, w := io.Pipe()
+	n.Stdin = r
+	n.Stdout = &lineWriter{net: net, src: id}
+
+	ep := &endpoint{node: n, inbox: newQueue()}
+
+	net.mu.Lock()
+	net.nodes[id] = ep
+	net.mu.Unlock()
+
+	go func() {
+		if err := n.Run(); err != nil {
+			log.Printf("simnet: node %s stopped: %v", id, err)
+		}
+	}()
+	go func() {
+		defer w.Close()
+		for {
+			line, ok := ep.inbox.pop()
+			if !ok {
+				return
+			}
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// Init sends an "init" message to every node, telling each about all of the nodes in ids,
+// and waits for every "init_ok".
+func (net *Network) Init(ctx context.Context, ids []string) error {
+	for _, id := range ids {
+		body := map[string]any{"type": "init", "node_id": id, "node_ids": ids}
+		if _, err := net.Call(ctx, id, body); err != nil {
+			return fmt.Errorf("init %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// Call sends body to node id as a client request and waits for the reply.
+// An error reply is returned as an *maelstrom.RPCError.
+func (net *Network) Call(ctx context.Context, id string, body map[string]any) (maelstrom.Message, error) {
+	msgID := int(net.nextMsgID.Add(1))
+	ch := make(chan maelstrom.Message, 1)
+
+	net.mu.Lock()
+	net.pending[msgID] = ch
+	net.mu.Unlock()
+
+	defer func() {
+		net.mu.Lock()
+		delete(net.pending, msgID)
+		net.mu.Unlock()
+	}()
+
+	req := make(map[string]any, len(body)+1)
+	for k, v := range body {
+		req[k] = v
+	}
+	req["msg_id"] = msgID
+
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return maelstrom.Message{}, err
+	}
+	net.deliver(maelstrom.Message{Src: ClientID, Dest: id, Body: buf})
+
+	select {
+	case <-ctx.Done():
+		return maelstrom.Message{}, ctx.Err()
+	case m := <-ch:
+		if err := m.RPCError(); err != nil {
+			return m, err
+		}
+		return m, nil
+	}
+}
+
+// Partition drops all traffic between every node in a and every node in b, in both directions.
+func (net *Network) Partition(a, b []string) {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+
+	for _, x := range a {
+		for _, y := range b {
+			net.blocked[[2]string{x, y}] = true
+			net.blocked[[2]string{y, x}] = true
+		}
+	}
+}
+
+// SetLatency delays every message between nodes and services by d, in each direction, so a
+// request to a service takes 2*d to answer. Messages to and from the client are not delayed.
+func (net *Network) SetLatency(d time.Duration) {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	net.latency = d
+}
+
+// Heal removes all partitions.
+func (net *Network) Heal() {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	net.blocked = make(map[[2]string]bool)
+}
+
+// Close closes every node's stdin so that its Run loop exits once in-flight handlers finish.
+// It doesn't wait for them, since a handler may be blocked on a request that will never be answered.
+func (net *Network) Close() {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+
+	for _, ep := range net.nodes {
+		ep.inbox.close()
+	}
+}
+
+// route delivers a message sent by a node or a service, after the network's latency.
+func (net *Network) route(m maelstrom.Message) {
+	net.mu.Lock()
+	svc := net.services[m.Dest]
+	latency := net.latency
+	net.mu.Unlock()
+
+	send := func() {
+		if svc != nil {
+			net.handleService(svc, m)
+			return
+		}
+		net.deliver(m)
+	}
+
+	if latency > 0 && m.Dest != ClientID {
+		time.AfterFunc(latency, send)
+		return
+	}
+	send()
+}
+
+// deliver passes m to its destination node or to the client, unless it crosses a partition.
+func (net *Network) deliver(m maelstrom.Message) {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+
+	if m.Dest == ClientID {
+		var body maelstrom.MessageBody
+		if err := json.Unmarshal(m.Body, &body); err != nil {
+			return
+		}
+		if ch := net.pending[body.InReplyTo]; ch != nil {
+			ch <- m
+			delete(net.pending, body.InReplyTo)
+		}
+		return
+	}
+
+	if net.blocked[[2]string{m.Src, m.Dest}] {
+		return
+	}
+
+	ep := net.nodes[m.Dest]
+	if ep == nil {
+		return
+	}
+
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	ep.inbox.push(append(buf, '\n'))
+}
+
+// handleService passes a request to a service and delivers its reply back to the sender.
+func (net *Network) handleService(svc Service, m maelstrom.Message) {
+	var body map[string]any
+	if err := json.Unmarshal(m.Body, &body); err != nil {
+		return
+	}
+
+	reply := svc.Handle(m.Src, body)
+	if reply == nil {
+		return
+	}
+	reply["in_reply_to"] = body["msg_id"]
+
+	buf, err := json.Marshal(reply)
+	if err != nil {
+		return
+	}
+	net.route(maelstrom.Message{Src: m.Dest, Dest: m.Src, Body: buf})
+}
+
+// lineWriter is a node's stdout. It parses each line the node writes and routes it.
+type lineWriter struct {
+	net *Network
+	src string
+	buf []byte
+}
+
+// Write implements io.Writer. maelstrom.Node writes a message and its newline in separate calls.
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := w.buf[:i]
+		w.buf = w.buf[i+1:]
+
+		var m maelstrom.Message
+		if err := json.Unmarshal(line, &m); err != nil {
+			log.Printf("simnet: malformed message from %s: %s", w.src, line)
+			continue
+		}
+		w.net.route(m)
+	}
+}
+
+// queue is an unbounded FIFO of lines. Nodes write to stdout while holding their own lock,
+// so delivery must never block on the receiving node, or two nodes could deadlock.
+type queue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  [][]byte
+	closed bool
+}
+
+func newQueue() *queue {
+	q := &queue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *queue) push(line []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.items = append(q.items, line)
+	q.cond.Signal()
+}
+
+// pop blocks until a line is available or the queue is closed.
+func (q *queue) pop() ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil, false
+	}
+	line := q.items[0]
+	q.items = q.items[1:]
+	return line, true
+}
+
+func (q *queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
@@ -0,0 +1,213 @@
+package maelstrom
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheOptions configures a CachedKV.
+type CacheOptions struct {
+	// TTL is how long a cached value is used before it is read again.
+	TTL time.Duration
+
+	// KeyTTL, if set, returns the TTL of key instead, so that hot keys that
+	// rarely change can be cached longer than the rest.
+	KeyTTL func(key string) time.Duration
+
+	// Strict makes every Read go to the store, as a linearizable read must:
+	// a cached value may be stale. Writes still update the cache.
+	Strict bool
+
+	// Now is the clock; nil for time.Now.
+	Now func() time.Time
+}
+
+// CacheStats is a snapshot of a CachedKV's metrics.
+type CacheStats struct {
+	Hits      int64 `json:"hits"`      // Reads served from the cache.
+	Misses    int64 `json:"misses"`    // Reads of keys not cached, or cached too long ago.
+	Bypassed  int64 `json:"bypassed"`  // Reads sent to the store because of Strict.
+	Refreshes int64 `json:"refreshes"` // Values read again after a compare-and-swap failed.
+}
+
+// HitRate returns the fraction of reads served from the cache, or 0 if there
+// were none.
+func (s CacheStats) HitRate() float64 {
+	reads := s.Hits + s.Misses + s.Bypassed
+	if reads == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(reads)
+}
+
+// CachedKV is a client to the key/value store that caches the values it reads
+// and writes, so hot keys aren't read over the network on every request.
+//
+// A value read is cached for its TTL. A successful write or compare-and-swap
+// caches the value written. A compare-and-swap that fails with
+// PreconditionFailed means the cached value is stale, so the current value is
+// read and cached instead, ready for the caller's retry.
+//
+// Writes from other nodes are only seen once an entry expires, so reads may
+// be stale by up to the TTL; set Strict where that isn't acceptable. It is
+// safe for concurrent use.
+type CachedKV struct {
+	kv   *KV
+	opts CacheOptions
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	gens    map[string]uint64 // Per key, bumped whenever its entry is written or dropped.
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	bypassed  atomic.Int64
+	refreshes atomic.Int64
+}
+
+// cacheEntry is a cached value and when it expires.
+type cacheEntry struct {
+	value   any
+	expires time.Time
+}
+
+// NewCachedKV returns a caching client that sends its requests through kv.
+func NewCachedKV(kv *KV, opts CacheOptions) *CachedKV {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &CachedKV{
+		kv:      kv,
+		opts:    opts,
+		entries: make(map[string]cacheEntry),
+		gens:    make(map[string]uint64),
+	}
+}
+
+// Stats returns a snapshot of the cache's metrics.
+func (c *CachedKV) Stats() CacheStats {
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Bypassed:  c.bypassed.Load(),
+		Refreshes: c.refreshes.Load(),
+	}
+}
+
+// Read returns the value for a given key, from the cache if it holds a value
+// that hasn't expired, and from the store otherwise.
+// Returns an *RPCError error with a KeyDoesNotExist code if the key does not
+// exist.
+func (c *CachedKV) Read(ctx context.Context, key string) (any, error) {
+	if c.opts.Strict {
+		c.bypassed.Add(1)
+		return c.fetch(ctx, key)
+	}
+
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && c.opts.Now().Before(e.expires) {
+		c.hits.Add(1)
+		return e.value, nil
+	}
+
+	c.misses.Add(1)
+	return c.fetch(ctx, key)
+}
+
+// Write overwrites the value for a given key in the store, and caches it.
+func (c *CachedKV) Write(ctx context.Context, key string, value any) error {
+	if err := c.kv.Write(ctx, key, value); err != nil {
+		c.forget(key)
+		return err
+	}
+	c.store(key, value)
+	return nil
+}
+
+// CompareAndSwap updates the value for a key if its current value matches the
+// previous value, and caches the new value. Creates the key if
+// createIfNotExists is true.
+//
+// Returns an *RPCError with a code of PreconditionFailed if the previous value
+// does not match, after caching the current value. Return a code of
+// KeyDoesNotExist if the key did not exist.
+func (c *CachedKV) CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error {
+	err := c.kv.CompareAndSwap(ctx, key, from, to, createIfNotExists)
+	switch {
+	case err == nil:
+		c.store(key, to)
+	case ErrorCode(err) == PreconditionFailed:
+		c.refreshes.Add(1)
+		c.fetch(ctx, key)
+	default:
+		c.forget(key)
+	}
+	return err
+}
+
+// fetch reads key from the store and caches its value, unless the cache
+// entry for key changed during the read. The read may have been served before
+// a write that has since been cached, so its value would be stale.
+func (c *CachedKV) fetch(ctx context.Context, key string) (any, error) {
+	c.mu.Lock()
+	gen := c.gens[key]
+	c.mu.Unlock()
+
+	v, err := c.kv.Read(ctx, key)
+	if err != nil {
+		c.forget(key)
+		return nil, err
+	}
+	c.cache(key, v, gen)
+	return v, nil
+}
+
+// store caches a value written to key, as Read would return it.
+func (c *CachedKV) store(key string, value any) {
+	buf, err := json.Marshal(value)
+	if err != nil {
+		c.forget(key)
+		return
+	}
+
+	var v any
+	if err := json.Unmarshal(buf, &v); err != nil {
+		c.forget(key)
+		return
+	}
+
+	c.mu.Lock()
+	gen := c.gens[key] + 1
+	c.gens[key] = gen
+	c.mu.Unlock()
+	c.cache(key, intValue(v), gen)
+}
+
+// cache caches v as the value of key until its TTL passes, if key's
+// generation is still gen.
+func (c *CachedKV) cache(key string, v any, gen uint64) {
+	ttl := c.opts.TTL
+	if c.opts.KeyTTL != nil {
+		ttl = c.opts.KeyTTL(key)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gens[key] != gen {
+		return
+	}
+	c.entries[key] = cacheEntry{value: v, expires: c.opts.Now().Add(ttl)}
+}
+
+// forget drops key from the cache.
+func (c *CachedKV) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gens[key]++
+	delete(c.entries, key)
+}
@@ -0,0 +1,179 @@
+package maelstrom_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// testClock is a clock that only moves when told to.
+type testClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func TestCachedKV_Read(t *testing.T) {
+	n, s := newKVNode(t)
+	s.set("hot", `1`)
+	s.set("cold", `2`)
+
+	clock := &testClock{t: time.Now()}
+	c := maelstrom.NewCachedKV(maelstrom.NewLinKV(n), maelstrom.CacheOptions{
+		TTL: time.Second,
+		KeyTTL: func(key string) time.Duration {
+			if key == "hot" {
+				return time.Minute
+			}
+			return time.Second
+		},
+		Now: clock.Now,
+	})
+
+	read := func(key string, want any) {
+		t.Helper()
+		if v, err := c.Read(context.Background(), key); err != nil {
+			t.Fatal(err)
+		} else if v != want {
+			t.Fatalf("%s=%v, want %v", key, v, want)
+		}
+	}
+
+	read("hot", 1)
+	read("cold", 2)
+	s.set("hot", `10`)
+	s.set("cold", `20`)
+	read("hot", 1) // Cached.
+	read("cold", 2)
+	if got := s.requests.Load(); got != 2 {
+		t.Fatalf("requests=%d, want 2", got)
+	}
+
+	// Each key expires after its own TTL.
+	clock.Add(2 * time.Second)
+	read("hot", 1)
+	read("cold", 20)
+	clock.Add(time.Minute)
+	read("hot", 10)
+
+	if got, want := c.Stats(), (maelstrom.CacheStats{Hits: 3, Misses: 4}); got != want {
+		t.Fatalf("stats=%+v, want %+v", got, want)
+	} else if got.HitRate() != 3.0/7 {
+		t.Fatalf("hit rate=%v, want 3/7", got.HitRate())
+	}
+
+	// Missing keys aren't cached.
+	if _, err := c.Read(context.Background(), "none"); maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.set("none", `3`)
+	read("none", 3)
+}
+
+func TestCachedKV_Write(t *testing.T) {
+	n, s := newKVNode(t)
+	c := maelstrom.NewCachedKV(maelstrom.NewLinKV(n), maelstrom.CacheOptions{TTL: time.Minute})
+
+	// Written values are cached as Read would return them.
+	if err := c.Write(context.Background(), "foo", int64(5)); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Read(context.Background(), "foo"); err != nil || v != 5 {
+		t.Fatalf("value=%v (%T), err=%v, want 5", v, v, err)
+	}
+	if err := c.CompareAndSwap(context.Background(), "foo", 5, 6, false); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Read(context.Background(), "foo"); err != nil || v != 6 {
+		t.Fatalf("value=%v, err=%v, want 6", v, err)
+	}
+	if got := s.requests.Load(); got != 2 {
+		t.Fatalf("requests=%d, want 2", got)
+	}
+}
+
+func TestCachedKV_CompareAndSwap_Refresh(t *testing.T) {
+	n, s := newKVNode(t)
+	c := maelstrom.NewCachedKV(maelstrom.NewLinKV(n), maelstrom.CacheOptions{TTL: time.Minute})
+
+	if err := c.Write(context.Background(), "foo", 1); err != nil {
+		t.Fatal(err)
+	}
+	s.set("foo", `7`) // Written by another node.
+
+	// The stale cached value fails the swap, which refreshes it.
+	if v, _ := c.Read(context.Background(), "foo"); v != 1 {
+		t.Fatalf("value=%v, want the cached 1", v)
+	}
+	if err := c.CompareAndSwap(context.Background(), "foo", 1, 2, false); maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	v, err := c.Read(context.Background(), "foo")
+	if err != nil || v != 7 {
+		t.Fatalf("value=%v, err=%v, want 7", v, err)
+	}
+	if err := c.CompareAndSwap(context.Background(), "foo", v, 8, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Stats().Refreshes; got != 1 {
+		t.Fatalf("refreshes=%d, want 1", got)
+	}
+}
+
+func TestCachedKV_Strict(t *testing.T) {
+	n, s := newKVNode(t)
+	c := maelstrom.NewCachedKV(maelstrom.NewLinKV(n), maelstrom.CacheOptions{TTL: time.Minute, Strict: true})
+
+	if err := c.Write(context.Background(), "foo", 1); err != nil {
+		t.Fatal(err)
+	}
+	s.set("foo", `2`)
+	if v, err := c.Read(context.Background(), "foo"); err != nil || v != 2 {
+		t.Fatalf("value=%v, err=%v, want 2", v, err)
+	}
+	if got, want := c.Stats(), (maelstrom.CacheStats{Bypassed: 1}); got != want {
+		t.Fatalf("stats=%+v, want %+v", got, want)
+	}
+}
+
+// Ensure a read that was served before a write, but returns after it, doesn't
+// replace the written value in the cache.
+func TestCachedKV_StaleFetch(t *testing.T) {
+	n, s := newKVNode(t)
+	s.set("foo", `1`)
+	s.readDelay = 100 * time.Millisecond
+	c := maelstrom.NewCachedKV(maelstrom.NewLinKV(n), maelstrom.CacheOptions{TTL: time.Minute})
+
+	readCh := make(chan any)
+	go func() {
+		v, _ := c.Read(context.Background(), "foo")
+		readCh <- v
+	}()
+
+	// Write once the store has read 1 but before the read returns.
+	time.Sleep(20 * time.Millisecond)
+	if err := c.Write(context.Background(), "foo", 2); err != nil {
+		t.Fatal(err)
+	}
+	if v := <-readCh; v != 1 {
+		t.Fatalf("concurrent read=%v, want 1", v)
+	}
+
+	if v, err := c.Read(context.Background(), "foo"); err != nil || v != 2 {
+		t.Fatalf("value=%v, err=%v, want 2", v, err)
+	}
+}
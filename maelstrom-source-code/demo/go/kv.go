@@ -3,6 +3,7 @@ package maelstrom
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 )
 
 // Types of key/value stores.
@@ -16,6 +17,10 @@ const (
 type KV struct {
 	typ  string
 	node *Node
+
+	// Retry bounds how long Update retries after losing a compare-and-swap
+	// race. The zero value means DefaultRetryPolicy.
+	Retry RetryPolicy
 }
 
 // NewKV returns a new instance a KV client for a node.
@@ -38,10 +43,7 @@ func NewLWWKV(node *Node) *KV { return NewKV(LWWKV, node) }
 // Read returns the value for a given key in the key/value store.
 // Returns an *RPCError error with a KeyDoesNotExist code if the key does not exist.
 func (kv *KV) Read(ctx context.Context, key string) (any, error) {
-	resp, err := kv.node.SyncRPC(ctx, kv.typ, kvReadMessageBody{
-		MessageBody: MessageBody{Type: "read"},
-		Key:         key,
-	})
+	resp, err := kv.read(ctx, key)
 	if err != nil {
 		return nil, err
 	}
@@ -51,30 +53,37 @@ func (kv *KV) Read(ctx context.Context, key string) (any, error) {
 	if err := json.Unmarshal(resp.Body, &body); err != nil {
 		return nil, err
 	}
+	return intValue(body.Value), nil
+}
 
-	// Convert numbers to integers since that's what maelstrom workloads use.
-	switch v := body.Value.(type) {
-	case float64:
-		return int(v), nil
-	default:
-		return v, nil
+// intValue converts a float64 to an int since that's what maelstrom workloads
+// use. Other values are returned as is.
+func intValue(v any) any {
+	if f, ok := v.(float64); ok {
+		return int(f)
 	}
+	return v
 }
 
 // ReadInt reads the value of a key in the key/value store as an int.
+// Returns an error wrapping ErrValueType if the value is not an integer.
 func (kv *KV) ReadInt(ctx context.Context, key string) (int, error) {
-	v, err := kv.Read(ctx, key)
-	i, _ := v.(int)
-	return i, err
+	return NewTypedKV[int](kv).Read(ctx, key)
 }
 
 // Write overwrites the value for a given key in the key/value store.
 func (kv *KV) Write(ctx context.Context, key string, value any) error {
-	_, err := kv.node.SyncRPC(ctx, kv.typ, kvWriteMessageBody{
+	return kv.write(ctx, key, value, false)
+}
+
+// write is Write, sending the numbers in value exactly if exact is set.
+// Otherwise they pass through float64, as they always have.
+func (kv *KV) write(ctx context.Context, key string, value any, exact bool) error {
+	_, err := kv.node.syncRPC(ctx, kv.typ, kvWriteMessageBody{
 		MessageBody: MessageBody{Type: "write"},
 		Key:         key,
 		Value:       value,
-	})
+	}, exact)
 	return err
 }
 
@@ -84,16 +93,89 @@ func (kv *KV) Write(ctx context.Context, key string, value any) error {
 // Returns an *RPCError with a code of PreconditionFailed if the previous value
 // does not match. Return a code of KeyDoesNotExist if the key did not exist.
 func (kv *KV) CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error {
-	_, err := kv.node.SyncRPC(ctx, kv.typ, kvCASMessageBody{
+	return kv.compareAndSwap(ctx, key, from, to, createIfNotExists, false)
+}
+
+// compareAndSwap is CompareAndSwap, sending the numbers in from and to
+// exactly if exact is set.
+func (kv *KV) compareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists, exact bool) error {
+	_, err := kv.node.syncRPC(ctx, kv.typ, kvCASMessageBody{
 		MessageBody:       MessageBody{Type: "cas"},
 		Key:               key,
 		From:              from,
 		To:                to,
 		CreateIfNotExists: createIfNotExists,
-	})
+	}, exact)
 	return err
 }
 
+// Update replaces the value of key with fn applied to it, using an optimistic
+// read-modify-write loop: it reads the key, calls fn, and compare-and-swaps
+// the result in. If another write got there first, it backs off and retries,
+// as bounded by kv.Retry, until it succeeds or ctx is done.
+//
+// fn is given the current value, as Read returns it, and whether the key
+// exists; a missing key is created. It may be called once per attempt, and an
+// error from it is returned as is.
+//
+// Returns the value committed. Returns an *RPCError with a code of
+// TemporarilyUnavailable if every attempt lost a race, in which case the
+// update definitely did not happen, and ctx's error if ctx is done first.
+func (kv *KV) Update(ctx context.Context, key string, fn func(old any, exists bool) (any, error)) (any, error) {
+	policy := kv.Retry
+	if policy == (RetryPolicy{}) {
+		policy = DefaultRetryPolicy
+	}
+
+	for attempt := 1; ; attempt++ {
+		// Swap from the value exactly as stored, so no rounding of it can
+		// fail the precondition.
+		var body struct {
+			Value json.RawMessage `json:"value"`
+		}
+		var old any
+		exists := true
+		if resp, err := kv.read(ctx, key); ErrorCode(err) == KeyDoesNotExist {
+			exists = false
+		} else if err != nil {
+			return nil, err
+		} else if err := json.Unmarshal(resp.Body, &body); err != nil {
+			return nil, err
+		} else if err := json.Unmarshal(body.Value, &old); err != nil {
+			return nil, err
+		}
+
+		v, err := fn(intValue(old), exists)
+		if err != nil {
+			return nil, err
+		}
+
+		err = kv.compareAndSwap(ctx, key, body.Value, v, !exists, true)
+		if err == nil {
+			return v, nil
+		} else if code := ErrorCode(err); code != PreconditionFailed && !(exists && code == KeyDoesNotExist) {
+			return nil, err
+		}
+
+		// Another write won, or deleted the key we read.
+		if attempt >= policy.MaxAttempts {
+			return nil, NewRPCError(TemporarilyUnavailable,
+				fmt.Sprintf("update of %q lost %d compare-and-swap races", key, attempt))
+		}
+		if err := policy.Backoff(ctx, attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// read sends a "read" request for key and returns the "read_ok" response.
+func (kv *KV) read(ctx context.Context, key string) (Message, error) {
+	return kv.node.SyncRPC(ctx, kv.typ, kvReadMessageBody{
+		MessageBody: MessageBody{Type: "read"},
+		Key:         key,
+	})
+}
+
 // kvReadMessageBody represents the body for the KV "read" message.
 type kvReadMessageBody struct {
 	MessageBody
@@ -106,7 +188,7 @@ type kvReadOKMessageBody struct {
 	Value any `json:"value"`
 }
 
-// kvWriteMessageBody represents the body for the KV "cas" message.
+// kvWriteMessageBody represents the body for the KV "write" message.
 type kvWriteMessageBody struct {
 	MessageBody
 	Key   string `json:"key"`
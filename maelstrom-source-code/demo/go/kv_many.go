@@ -0,0 +1,118 @@
+package maelstrom
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultParallelism is how many requests ReadMany and WriteMany have in
+// flight at once unless ManyOptions says otherwise.
+const DefaultParallelism = 8
+
+// ManyOptions configures ReadMany and WriteMany.
+type ManyOptions struct {
+	// Parallelism is the most requests in flight at once. Zero or less means
+	// DefaultParallelism.
+	Parallelism int
+
+	// DefaultMissing makes ReadMany return Default as the value of a key that
+	// does not exist, instead of a KeyDoesNotExist error.
+	DefaultMissing bool
+	Default        any
+}
+
+// KeyResult is the outcome of reading one key with ReadMany.
+type KeyResult struct {
+	Value any
+	Err   error
+}
+
+// ReadMany reads keys concurrently, with at most opts.Parallelism requests in
+// flight at once, and returns the result for each key. A key listed more than
+// once is read once. Each result holds what Read would have returned for the
+// key, except that a missing key is given opts.Default if opts.DefaultMissing
+// is set. Keys left unread when ctx is done get ctx's error.
+func (kv *KV) ReadMany(ctx context.Context, keys []string, opts ManyOptions) map[string]KeyResult {
+	seen := make(map[string]bool, len(keys))
+	unique := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+
+	var mu sync.Mutex
+	results := make(map[string]KeyResult, len(unique))
+	fanOut(ctx, len(unique), opts.Parallelism, func(i int) {
+		key := unique[i]
+		v, err := kv.Read(ctx, key)
+		if opts.DefaultMissing && ErrorCode(err) == KeyDoesNotExist {
+			v, err = opts.Default, nil
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		results[key] = KeyResult{Value: v, Err: err}
+	}, func(i int) {
+		mu.Lock()
+		defer mu.Unlock()
+		results[unique[i]] = KeyResult{Err: ctx.Err()}
+	})
+	return results
+}
+
+// WriteMany writes values concurrently, with at most opts.Parallelism requests
+// in flight at once, and returns the error of each write: nil if it succeeded.
+// Keys left unwritten when ctx is done get ctx's error.
+func (kv *KV) WriteMany(ctx context.Context, values map[string]any, opts ManyOptions) map[string]error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	var mu sync.Mutex
+	errs := make(map[string]error, len(values))
+	record := func(key string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs[key] = err
+	}
+	fanOut(ctx, len(keys), opts.Parallelism, func(i int) {
+		record(keys[i], kv.Write(ctx, keys[i], values[keys[i]]))
+	}, func(i int) {
+		record(keys[i], ctx.Err())
+	})
+	return errs
+}
+
+// fanOut calls do for each index in [0, n) with at most parallelism calls
+// running at once, and returns once they have all finished. Indexes not yet
+// started when ctx is done are passed to skip instead.
+func fanOut(ctx context.Context, n, parallelism int, do, skip func(i int)) {
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallelism)
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			for ; i < n; i++ {
+				skip(i)
+			}
+			wg.Wait()
+			return
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			do(i)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,456 @@
+package maelstrom_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// kvService answers the key/value requests a node sends, as a linearizable
+// store would. Values are kept as the JSON they were written with.
+type kvService struct {
+	mu   sync.Mutex
+	data map[string]json.RawMessage
+
+	delay       time.Duration // How long each request takes; set before the first.
+	readDelay   time.Duration // How long a read's response takes after the value is read; set before the first.
+	requests    atomic.Int64  // Requests handled.
+	inFlight    atomic.Int64  // Requests being handled.
+	maxInFlight atomic.Int64  // Most requests handled at once.
+}
+
+// serveKV answers every request the node writes to stdout from a new
+// kvService, writing the responses to stdin.
+func serveKV(tb testing.TB, stdin io.Writer, stdout *bufio.Reader) *kvService {
+	s := &kvService{data: make(map[string]json.RawMessage)}
+	go func() {
+		for {
+			line, err := stdout.ReadBytes('\n')
+			if err != nil {
+				return
+			}
+
+			var req maelstrom.Message
+			if err := json.Unmarshal(line, &req); err != nil {
+				tb.Errorf("unmarshal request: %s", err)
+				return
+			}
+			var reqBody maelstrom.MessageBody
+			if err := json.Unmarshal(req.Body, &reqBody); err != nil {
+				tb.Errorf("unmarshal request body: %s", err)
+				return
+			}
+
+			// Handle requests concurrently, as the network would deliver them,
+			// and respond without blocking: the node may be writing its next
+			// request, and can't read a response until that is read.
+			go func() {
+				body := s.handle(req.Body)
+				if reqBody.Type == "read" {
+					time.Sleep(s.readDelay)
+				}
+				body["in_reply_to"] = reqBody.MsgID
+				buf, err := json.Marshal(maelstrom.Message{Src: req.Dest, Dest: req.Src, Body: mustMarshal(tb, body)})
+				if err != nil {
+					tb.Errorf("marshal response: %s", err)
+					return
+				}
+				stdin.Write(append(buf, '\n'))
+			}()
+		}
+	}()
+	return s
+}
+
+// handle applies a read, write or cas request body and returns the response body.
+func (s *kvService) handle(reqBody json.RawMessage) map[string]any {
+	var req struct {
+		Type              string          `json:"type"`
+		Key               string          `json:"key"`
+		Value             json.RawMessage `json:"value"`
+		From              json.RawMessage `json:"from"`
+		To                json.RawMessage `json:"to"`
+		CreateIfNotExists bool            `json:"create_if_not_exists"`
+	}
+	if err := json.Unmarshal(reqBody, &req); err != nil {
+		return map[string]any{"type": "error", "code": maelstrom.MalformedRequest, "text": err.Error()}
+	}
+
+	s.requests.Add(1)
+	for n, max := s.inFlight.Add(1), s.maxInFlight.Load(); n > max && !s.maxInFlight.CompareAndSwap(max, n); max = s.maxInFlight.Load() {
+	}
+	defer s.inFlight.Add(-1)
+	time.Sleep(s.delay)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.data[req.Key]
+	switch req.Type {
+	case "read":
+		if !ok {
+			return map[string]any{"type": "error", "code": maelstrom.KeyDoesNotExist, "text": "key does not exist"}
+		}
+		return map[string]any{"type": "read_ok", "value": v}
+	case "write":
+		s.data[req.Key] = req.Value
+		return map[string]any{"type": "write_ok"}
+	case "cas":
+		if !ok && !req.CreateIfNotExists {
+			return map[string]any{"type": "error", "code": maelstrom.KeyDoesNotExist, "text": "key does not exist"}
+		} else if ok && !bytes.Equal(v, req.From) {
+			return map[string]any{"type": "error", "code": maelstrom.PreconditionFailed, "text": "current value " + string(v) + " is not " + string(req.From)}
+		}
+		s.data[req.Key] = req.To
+		return map[string]any{"type": "cas_ok"}
+	default:
+		return map[string]any{"type": "error", "code": maelstrom.NotSupported, "text": "unknown request " + req.Type}
+	}
+}
+
+// set stores value, as JSON, under key.
+func (s *kvService) set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = json.RawMessage(value)
+}
+
+// get returns the JSON stored under key.
+func (s *kvService) get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return string(s.data[key])
+}
+
+func mustMarshal(tb testing.TB, v any) json.RawMessage {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return buf
+}
+
+// newKVNode returns an initialized node whose key/value requests are answered
+// by the returned kvService.
+func newKVNode(t *testing.T) (*maelstrom.Node, *kvService) {
+	n, stdin, stdout := newNode(t)
+	initNode(t, n, "n1", []string{"n1"}, stdin, stdout)
+	return n, serveKV(t, stdin, stdout)
+}
+
+func TestTypedKV(t *testing.T) {
+	t.Run("LosslessInt64", func(t *testing.T) {
+		n, s := newKVNode(t)
+		kv := maelstrom.NewTypedKV[int64](maelstrom.NewLinKV(n))
+
+		// 2^62+1 has no float64 representation.
+		const want = int64(1<<62 + 1)
+		if err := kv.Write(context.Background(), "foo", want); err != nil {
+			t.Fatal(err)
+		} else if got := s.get("foo"); got != "4611686018427387905" {
+			t.Fatalf("stored %s, want 4611686018427387905", got)
+		}
+		if got, err := kv.Read(context.Background(), "foo"); err != nil {
+			t.Fatal(err)
+		} else if got != want {
+			t.Fatalf("value=%d, want %d", got, want)
+		}
+
+		if err := kv.CompareAndSwap(context.Background(), "foo", want, want+1, false); err != nil {
+			t.Fatal(err)
+		} else if err := kv.CompareAndSwap(context.Background(), "foo", want, want+2, false); maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, err := kv.Read(context.Background(), "foo"); err != nil || got != want+1 {
+			t.Fatalf("value=%d, err=%v, want %d", got, err, want+1)
+		}
+	})
+
+	t.Run("AnyAsNumber", func(t *testing.T) {
+		n, s := newKVNode(t)
+		s.set("foo", `[1.5, 4611686018427387905]`)
+
+		got, err := maelstrom.NewTypedKV[any](maelstrom.NewLinKV(n)).Read(context.Background(), "foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []any{json.Number("1.5"), json.Number("4611686018427387905")}
+		if b, w := mustMarshal(t, got), mustMarshal(t, want); !bytes.Equal(b, w) {
+			t.Fatalf("value=%s, want %s", b, w)
+		}
+		if _, ok := got.([]any)[0].(json.Number); !ok {
+			t.Fatalf("element type=%T, want json.Number", got.([]any)[0])
+		}
+	})
+
+	t.Run("Struct", func(t *testing.T) {
+		type payload struct {
+			Counter int64
+		}
+		n, s := newKVNode(t)
+		s.set("foo", `{"Counter":13}`)
+
+		if got, err := maelstrom.NewTypedKV[payload](maelstrom.NewLinKV(n)).Read(context.Background(), "foo"); err != nil {
+			t.Fatal(err)
+		} else if got.Counter != 13 {
+			t.Fatalf("counter=%d, want 13", got.Counter)
+		}
+	})
+
+	t.Run("ErrValueType", func(t *testing.T) {
+		n, s := newKVNode(t)
+		kv := maelstrom.NewLinKV(n)
+		s.set("frac", `1.5`)
+		s.set("str", `"1"`)
+		s.set("obj", `{"Counter":1,"Extra":2}`)
+
+		if _, err := maelstrom.NewTypedKV[int](kv).Read(context.Background(), "frac"); !errors.Is(err, maelstrom.ErrValueType) {
+			t.Fatalf("fraction as int: unexpected error: %v", err)
+		}
+		if _, err := maelstrom.NewTypedKV[int](kv).Read(context.Background(), "str"); !errors.Is(err, maelstrom.ErrValueType) {
+			t.Fatalf("string as int: unexpected error: %v", err)
+		}
+		if _, err := maelstrom.NewTypedKV[struct{ Counter int }](kv).Read(context.Background(), "obj"); !errors.Is(err, maelstrom.ErrValueType) {
+			t.Fatalf("unknown field: unexpected error: %v", err)
+		}
+		if _, err := kv.ReadInt(context.Background(), "str"); !errors.Is(err, maelstrom.ErrValueType) {
+			t.Fatalf("ReadInt of a string: unexpected error: %v", err)
+		}
+	})
+
+	t.Run("KeyDoesNotExist", func(t *testing.T) {
+		n, _ := newKVNode(t)
+		if _, err := maelstrom.NewTypedKV[int](maelstrom.NewLinKV(n)).Read(context.Background(), "foo"); maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Ensure KV.Write and KV.CompareAndSwap still send numbers as float64, as
+// they did before TypedKV, which sends them exactly.
+func TestKV_Float64(t *testing.T) {
+	n, s := newKVNode(t)
+	kv := maelstrom.NewLinKV(n)
+
+	// 2^62+1 has no float64 representation, so it is written rounded.
+	if err := kv.Write(context.Background(), "foo", int64(1<<62+1)); err != nil {
+		t.Fatal(err)
+	} else if got := s.get("foo"); got != "4611686018427388000" {
+		t.Fatalf("stored %s, want 4611686018427388000", got)
+	}
+
+	// A swap from the same int64 is rounded the same way, so it matches.
+	if err := kv.CompareAndSwap(context.Background(), "foo", int64(1<<62+1), "bar", false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKV_Update(t *testing.T) {
+	t.Run("Concurrent", func(t *testing.T) {
+		n, s := newKVNode(t)
+		kv := maelstrom.NewLinKV(n)
+
+		// Each goroutine adds 1 ten times; every lost race is retried.
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					if _, err := kv.Update(context.Background(), "foo", func(old any, exists bool) (any, error) {
+						if !exists {
+							return 1, nil
+						}
+						return old.(int) + 1, nil
+					}); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		if got := s.get("foo"); got != "80" {
+			t.Fatalf("value=%s, want 80", got)
+		}
+	})
+
+	t.Run("SwapsFromStoredValue", func(t *testing.T) {
+		n, s := newKVNode(t)
+		s.set("foo", `1.5`)
+
+		// The value reads as 1, like Read, but the swap is from 1.5 as stored.
+		v, err := maelstrom.NewLinKV(n).Update(context.Background(), "foo", func(old any, exists bool) (any, error) {
+			return old.(int) + 1, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		} else if v != 2 || s.get("foo") != "2" {
+			t.Fatalf("committed %v, stored %s, want 2", v, s.get("foo"))
+		}
+	})
+
+	t.Run("SwapsFromLargeInt", func(t *testing.T) {
+		n, s := newKVNode(t)
+		s.set("foo", `4611686018427387905`)
+
+		// 2^62+1 has no float64 representation, so the swap only matches if
+		// it is sent exactly.
+		if _, err := maelstrom.NewLinKV(n).Update(context.Background(), "foo", func(old any, exists bool) (any, error) {
+			return "bar", nil
+		}); err != nil {
+			t.Fatal(err)
+		} else if got := s.get("foo"); got != `"bar"` {
+			t.Fatalf("stored %s, want \"bar\"", got)
+		}
+	})
+
+	t.Run("ErrRetriesExhausted", func(t *testing.T) {
+		n, s := newKVNode(t)
+		kv := maelstrom.NewLinKV(n)
+		kv.Retry = maelstrom.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+		// A concurrent writer changes the key during every attempt.
+		calls := 0
+		_, err := kv.Update(context.Background(), "foo", func(old any, exists bool) (any, error) {
+			calls++
+			s.set("foo", fmt.Sprint(calls))
+			return -1, nil
+		})
+		if maelstrom.ErrorCode(err) != maelstrom.TemporarilyUnavailable {
+			t.Fatalf("unexpected error: %v", err)
+		} else if calls != 3 {
+			t.Fatalf("fn called %d times, want 3", calls)
+		}
+	})
+
+	t.Run("ErrDeadline", func(t *testing.T) {
+		n, s := newKVNode(t)
+		kv := maelstrom.NewLinKV(n)
+		kv.Retry = maelstrom.RetryPolicy{MaxAttempts: 1 << 30, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		calls := 0
+		_, err := kv.Update(ctx, "foo", func(old any, exists bool) (any, error) {
+			calls++
+			s.set("foo", fmt.Sprint(calls))
+			return -1, nil
+		})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("ErrFn", func(t *testing.T) {
+		n, s := newKVNode(t)
+		s.set("foo", `1`)
+
+		errFn := errors.New("marker")
+		if _, err := maelstrom.NewLinKV(n).Update(context.Background(), "foo", func(any, bool) (any, error) {
+			return nil, errFn
+		}); err != errFn {
+			t.Fatalf("unexpected error: %v", err)
+		} else if s.get("foo") != "1" {
+			t.Fatalf("value=%s, want 1", s.get("foo"))
+		}
+	})
+}
+
+func TestKV_ReadMany(t *testing.T) {
+	n, s := newKVNode(t)
+	s.delay = 10 * time.Millisecond
+	kv := maelstrom.NewLinKV(n)
+
+	var keys []string
+	for i := 0; i < 40; i++ {
+		keys = append(keys, fmt.Sprintf("k%d", i))
+		if i%4 != 0 {
+			s.set(keys[i], fmt.Sprint(i))
+		}
+	}
+	keys = append(keys, "k1") // Read once.
+
+	results := kv.ReadMany(context.Background(), keys, maelstrom.ManyOptions{Parallelism: 4})
+	if len(results) != 40 {
+		t.Fatalf("len=%d, want 40", len(results))
+	}
+	for i := 0; i < 40; i++ {
+		r := results[fmt.Sprintf("k%d", i)]
+		if i%4 == 0 && maelstrom.ErrorCode(r.Err) != maelstrom.KeyDoesNotExist {
+			t.Fatalf("k%d: unexpected error: %v", i, r.Err)
+		} else if i%4 != 0 && (r.Err != nil || r.Value != i) {
+			t.Fatalf("k%d: value=%v, err=%v, want %d", i, r.Value, r.Err, i)
+		}
+	}
+	if got := s.maxInFlight.Load(); got > 4 || got < 2 {
+		t.Fatalf("max in flight=%d, want 2 to 4", got)
+	}
+
+	// Missing keys can read as a default instead.
+	results = kv.ReadMany(context.Background(), keys[:8], maelstrom.ManyOptions{DefaultMissing: true, Default: 0})
+	for i := 0; i < 8; i++ {
+		r := results[fmt.Sprintf("k%d", i)]
+		if want := i; i%4 == 0 && (r.Err != nil || r.Value != 0) || i%4 != 0 && r.Value != want {
+			t.Fatalf("k%d: value=%v, err=%v", i, r.Value, r.Err)
+		}
+	}
+}
+
+func TestKV_ReadMany_Cancel(t *testing.T) {
+	n, s := newKVNode(t)
+	s.delay = 20 * time.Millisecond
+
+	var keys []string
+	for i := 0; i < 20; i++ {
+		keys = append(keys, fmt.Sprintf("k%d", i))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	results := maelstrom.NewLinKV(n).ReadMany(ctx, keys, maelstrom.ManyOptions{Parallelism: 2})
+	if len(results) != 20 {
+		t.Fatalf("len=%d, want 20", len(results))
+	}
+	if err := results["k19"].Err; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestKV_WriteMany(t *testing.T) {
+	n, s := newKVNode(t)
+	s.delay = 10 * time.Millisecond
+
+	values := make(map[string]any)
+	for i := 0; i < 20; i++ {
+		values[fmt.Sprintf("k%d", i)] = i
+	}
+
+	errs := maelstrom.NewLinKV(n).WriteMany(context.Background(), values, maelstrom.ManyOptions{Parallelism: 3})
+	if len(errs) != 20 {
+		t.Fatalf("len=%d, want 20", len(errs))
+	}
+	for key, err := range errs {
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		} else if got, want := s.get(key), fmt.Sprint(values[key]); got != want {
+			t.Fatalf("%s=%s, want %s", key, got, want)
+		}
+	}
+	if got := s.maxInFlight.Load(); got > 3 {
+		t.Fatalf("max in flight=%d, want at most 3", got)
+	}
+}
@@ -0,0 +1,61 @@
+package lock
+
+import (
+	"context"
+	"time"
+)
+
+// Clock tells the time that leases expire by. Every node sharing a lock must
+// use the same kind of clock.
+type Clock interface {
+	// Now returns the current time in Unix milliseconds.
+	Now(ctx context.Context) (int64, error)
+
+	// Uncertainty bounds how far apart two nodes' readings of Now can be at
+	// the same instant. A lease is taken over only once it has expired by
+	// more than this, and its holder gives it up this long before it
+	// expires.
+	Uncertainty() time.Duration
+}
+
+// LocalClock is a Clock that reads the node's own wall clock. It is only safe
+// if every node's clock is within MaxSkew of every other's.
+type LocalClock struct {
+	MaxSkew time.Duration
+}
+
+// Now implements Clock.
+func (c LocalClock) Now(context.Context) (int64, error) {
+	return time.Now().UnixMilli(), nil
+}
+
+// Uncertainty implements Clock.
+func (c LocalClock) Uncertainty() time.Duration {
+	return c.MaxSkew
+}
+
+// Oracle issues timestamps in Unix milliseconds, each greater than every one
+// issued before it, that advance at the rate of real time.
+//
+// Maelstrom's lin-tso service is not such an oracle: its timestamps count
+// requests rather than milliseconds.
+type Oracle interface {
+	Timestamp(ctx context.Context) (int64, error)
+}
+
+// TSOClock is a Clock that asks a timestamp oracle for the time, so nodes
+// agree on it exactly whatever their own clocks say. Each reading costs a
+// request to the oracle.
+type TSOClock struct {
+	Oracle Oracle
+}
+
+// Now implements Clock.
+func (c TSOClock) Now(ctx context.Context) (int64, error) {
+	return c.Oracle.Timestamp(ctx)
+}
+
+// Uncertainty implements Clock. Every node reads the same clock.
+func (c TSOClock) Uncertainty() time.Duration {
+	return 0
+}
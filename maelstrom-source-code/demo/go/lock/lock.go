@@ -0,0 +1,301 @@
+// Package lock provides distributed locks with fencing tokens, built on
+// compare-and-swap against a linearizable key/value store such as lin-kv.
+//
+// A lock is held under a lease, which its holder renews in the background
+// until it releases the lock. If renewal fails, for example because the
+// holder is cut off from the store, the lease expires and another node may
+// take the lock over. The holder's Lease.Context is cancelled before that can
+// happen, so work done under it stops in time.
+//
+// Every acquisition of a lock gets a fencing token greater than the tokens of
+// all earlier acquisitions. A resource guarded by the lock should remember
+// the highest token it has seen and refuse requests that carry a lower one,
+// which protects it from a holder that has lost its lease but doesn't know it
+// yet, such as one paused by a long garbage collection.
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+var (
+	// ErrHeld is returned by TryAcquire when another owner holds the lock.
+	ErrHeld = errors.New("lock is held")
+
+	// ErrLost is returned by Release when the lease had already been lost.
+	ErrLost = errors.New("lease was lost")
+)
+
+// KV is the part of a key/value client, such as *maelstrom.KV, that a Locker
+// uses. The store must be linearizable.
+type KV interface {
+	Read(ctx context.Context, key string) (any, error)
+	CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error
+}
+
+// Config configures a Locker.
+type Config struct {
+	// TTL is how long a lease lasts without renewal. It must be positive,
+	// and longer than the Clock's uncertainty.
+	TTL time.Duration
+
+	// RenewEvery is how often a lease is renewed. It must be shorter than
+	// the time the holder may use a lease for, TTL less the Clock's
+	// uncertainty, or the lease would be given up before it is renewed.
+	// Zero means a third of that time.
+	RenewEvery time.Duration
+
+	// Clock tells the time that leases expire by. Nil means a LocalClock
+	// with a MaxSkew of TTL/10.
+	Clock Clock
+}
+
+// Locker acquires locks on behalf of one owner, usually a node. It is safe
+// for concurrent use.
+type Locker struct {
+	kv    KV
+	owner string
+	cfg   Config
+}
+
+// New returns a Locker that stores locks in kv, under the key "lock-" plus
+// the lock's name, and acquires them as owner. It returns an error if cfg
+// breaks the rules documented on Config.
+func New(kv KV, owner string, cfg Config) (*Locker, error) {
+	if cfg.TTL <= 0 {
+		return nil, fmt.Errorf("lock TTL must be positive, got %s", cfg.TTL)
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = LocalClock{MaxSkew: cfg.TTL / 10}
+	}
+
+	usable := cfg.TTL - cfg.Clock.Uncertainty()
+	if usable <= 0 {
+		return nil, fmt.Errorf("lock TTL %s must be longer than the clock's uncertainty, %s", cfg.TTL, cfg.Clock.Uncertainty())
+	}
+	if cfg.RenewEvery == 0 {
+		cfg.RenewEvery = usable / 3
+	}
+	if cfg.RenewEvery <= 0 || cfg.RenewEvery >= usable {
+		return nil, fmt.Errorf("lock renewal interval must be positive and shorter than TTL less the clock's uncertainty, %s, got %s", usable, cfg.RenewEvery)
+	}
+	return &Locker{kv: kv, owner: owner, cfg: cfg}, nil
+}
+
+// record is the value of a lock's key.
+type record struct {
+	Owner   string `json:"owner"`   // The holder, or "" if the lock is free.
+	Token   int64  `json:"token"`   // The fencing token of the latest acquisition.
+	Expires int64  `json:"expires"` // When the lease expires, in Unix milliseconds by the Clock.
+}
+
+// Acquire acquires the lock called name, waiting until it is free or ctx is
+// done. See TryAcquire.
+func (l *Locker) Acquire(ctx context.Context, name string) (*Lease, error) {
+	poll := maelstrom.RetryPolicy{BaseDelay: l.cfg.TTL / 20, MaxDelay: l.cfg.TTL / 4}
+	for attempt := 1; ; attempt++ {
+		lease, err := l.TryAcquire(ctx, name)
+		if !errors.Is(err, ErrHeld) {
+			return lease, err
+		}
+		if err := poll.Backoff(ctx, attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// TryAcquire acquires the lock called name if it is free, or if its lease has
+// expired. It returns an error wrapping ErrHeld if the lock is held, even if
+// by this owner: locks are not reentrant.
+//
+// ctx bounds the attempt only; the lease lasts until it is released or lost.
+// If ctx is done while the store is applying the acquisition, the lock may be
+// held by this owner without a Lease to release it, until the lease expires.
+func (l *Locker) TryAcquire(ctx context.Context, name string) (*Lease, error) {
+	key := "lock-" + name
+
+	old, stored, exists, err := l.read(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Measure the lease from before the time is read, so the holder's idea
+	// of when it ends is never later than the Clock's.
+	start := time.Now()
+	now, err := l.cfg.Clock.Now(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if old.Owner != "" && now <= old.Expires+l.cfg.Clock.Uncertainty().Milliseconds() {
+		return nil, fmt.Errorf("%w by %s until %d", ErrHeld, old.Owner, old.Expires)
+	}
+
+	rec := record{Owner: l.owner, Token: old.Token + 1, Expires: now + l.cfg.TTL.Milliseconds()}
+	if err := l.kv.CompareAndSwap(ctx, key, stored, rec, !exists); maelstrom.ErrorCode(err) == maelstrom.PreconditionFailed {
+		return nil, fmt.Errorf("%w: acquired by another owner first", ErrHeld)
+	} else if err != nil {
+		return nil, err
+	}
+
+	leaseCtx, cancel := context.WithCancel(context.Background())
+	lease := &Lease{
+		locker:   l,
+		key:      key,
+		token:    rec.Token,
+		ctx:      leaseCtx,
+		cancel:   cancel,
+		rec:      rec,
+		deadline: l.deadline(start),
+		released: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go lease.renew()
+	return lease, nil
+}
+
+// read returns the record of key, the value it was decoded from, for a
+// compare-and-swap to swap from, and whether the key exists.
+func (l *Locker) read(ctx context.Context, key string) (rec record, stored any, exists bool, err error) {
+	stored, err = l.kv.Read(ctx, key)
+	if maelstrom.ErrorCode(err) == maelstrom.KeyDoesNotExist {
+		return record{}, nil, false, nil
+	} else if err != nil {
+		return record{}, nil, false, err
+	}
+
+	buf, err := json.Marshal(stored)
+	if err != nil {
+		return record{}, nil, false, err
+	}
+	if err := json.Unmarshal(buf, &rec); err != nil {
+		return record{}, nil, false, fmt.Errorf("lock key %s holds %s, not a lock: %w", key, buf, err)
+	}
+	return rec, stored, true, nil
+}
+
+// deadline returns when a lease measured from start must be given up: its
+// TTL later, less the Clock's uncertainty.
+func (l *Locker) deadline(start time.Time) time.Time {
+	return start.Add(l.cfg.TTL - l.cfg.Clock.Uncertainty())
+}
+
+// Lease is a held lock.
+type Lease struct {
+	locker *Locker
+	key    string
+	token  int64
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex // mu guards access to rec and deadline.
+	rec      record     // The lock's record, as last written by this lease.
+	deadline time.Time  // When the lease must be given up unless renewed.
+
+	releaseOnce sync.Once
+	released    chan struct{} // Closed by Release.
+	done        chan struct{} // Closed when renewal stops.
+}
+
+// Token returns the lease's fencing token, greater than the tokens of every
+// earlier acquisition of the lock.
+func (l *Lease) Token() int64 {
+	return l.token
+}
+
+// Context returns a context that is cancelled when the lease is released or
+// lost. Work done under the lock should stop when it is.
+func (l *Lease) Context() context.Context {
+	return l.ctx
+}
+
+// renew renews the lease every RenewEvery until it is released or lost. The
+// lease is lost when a renewal finds that another owner has taken the lock, or
+// when the deadline passes without a successful renewal.
+func (l *Lease) renew() {
+	defer close(l.done)
+	defer l.cancel()
+
+	ticker := time.NewTicker(l.locker.cfg.RenewEvery)
+	defer ticker.Stop()
+
+	for {
+		l.mu.Lock()
+		deadline := time.NewTimer(time.Until(l.deadline))
+		l.mu.Unlock()
+
+		select {
+		case <-l.released:
+			deadline.Stop()
+			return
+		case <-deadline.C:
+			return
+		case <-ticker.C:
+			deadline.Stop()
+			if lost := l.renewOnce(); lost {
+				return
+			}
+		}
+	}
+}
+
+// renewOnce extends the lease by TTL, and reports whether it has been lost.
+// A renewal that fails for any other reason is retried on the next tick.
+func (l *Lease) renewOnce() (lost bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Don't let a renewal outlive the lease it renews.
+	ctx, cancel := context.WithDeadline(l.ctx, l.deadline)
+	defer cancel()
+
+	start := time.Now()
+	now, err := l.locker.cfg.Clock.Now(ctx)
+	if err != nil {
+		return false
+	}
+
+	rec := l.rec
+	rec.Expires = now + l.locker.cfg.TTL.Milliseconds()
+	err = l.locker.kv.CompareAndSwap(ctx, l.key, l.rec, rec, false)
+	if maelstrom.ErrorCode(err) == maelstrom.PreconditionFailed {
+		return true
+	} else if err != nil {
+		// The swap may still be applied; then the next renewal fails its
+		// precondition and the lease is given up early, which is safe.
+		return false
+	}
+
+	l.rec, l.deadline = rec, l.locker.deadline(start)
+	return false
+}
+
+// Release releases the lock, cancelling the lease's context first. It returns
+// ErrLost if the lease had already been lost, in which case the lock was not
+// released, and the store's error if the release failed, in which case the
+// lock is freed when the lease expires.
+func (l *Lease) Release(ctx context.Context) error {
+	l.releaseOnce.Do(func() { close(l.released) })
+	<-l.done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if time.Now().After(l.deadline) {
+		return ErrLost
+	}
+
+	free := l.rec
+	free.Owner, free.Expires = "", 0
+	err := l.locker.kv.CompareAndSwap(ctx, l.key, l.rec, free, false)
+	if maelstrom.ErrorCode(err) == maelstrom.PreconditionFailed {
+		return ErrLost
+	}
+	return err
+}
@@ -0,0 +1,327 @@
+package lock_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/jepsen-io/maelstrom/demo/go/lock"
+)
+
+// memKV is an in-memory linearizable key/value store, answering like lin-kv.
+// Values are kept as JSON, and compared by the values they decode to.
+type memKV struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func newMemKV() *memKV {
+	return &memKV{data: make(map[string][]byte)}
+}
+
+// node returns a client of the store for one node, which can be cut off.
+func (kv *memKV) node() *nodeKV {
+	return &nodeKV{kv: kv}
+}
+
+// nodeKV is one node's client of a memKV. While cut is set, its requests time
+// out, as in a partition.
+type nodeKV struct {
+	kv  *memKV
+	cut atomic.Bool
+}
+
+func (n *nodeKV) Read(ctx context.Context, key string) (any, error) {
+	if n.cut.Load() {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	n.kv.mu.Lock()
+	defer n.kv.mu.Unlock()
+
+	buf, ok := n.kv.data[key]
+	if !ok {
+		return nil, maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+	}
+	var v any
+	err := json.Unmarshal(buf, &v)
+	return v, err
+}
+
+func (n *nodeKV) CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error {
+	if n.cut.Load() {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	n.kv.mu.Lock()
+	defer n.kv.mu.Unlock()
+
+	buf, ok := n.kv.data[key]
+	if !ok && !createIfNotExists {
+		return maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+	}
+	if ok {
+		var cur any
+		if err := json.Unmarshal(buf, &cur); err != nil {
+			return err
+		}
+		fromBuf, err := json.Marshal(from)
+		if err != nil {
+			return err
+		}
+		var want any
+		if err := json.Unmarshal(fromBuf, &want); err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(cur, want) {
+			return maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("current value %s is not %s", buf, fromBuf))
+		}
+	}
+
+	toBuf, err := json.Marshal(to)
+	if err != nil {
+		return err
+	}
+	n.kv.data[key] = toBuf
+	return nil
+}
+
+// newLocker returns a Locker for owner, failing the test if cfg is invalid.
+func newLocker(t *testing.T, kv lock.KV, owner string, cfg lock.Config) *lock.Locker {
+	t.Helper()
+
+	l, err := lock.New(kv, owner, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// TestNew_Config checks that New rejects configurations whose leases would
+// panic or expire before they are renewed.
+func TestNew_Config(t *testing.T) {
+	skewed := lock.LocalClock{MaxSkew: 300 * time.Millisecond}
+	for _, c := range []struct {
+		name string
+		cfg  lock.Config
+		ok   bool
+	}{
+		{"defaults", lock.Config{TTL: time.Second}, true},
+		{"default renewal with a skewed clock", lock.Config{TTL: time.Second, Clock: skewed}, true},
+		{"renewal just in time", lock.Config{TTL: time.Second, RenewEvery: 699 * time.Millisecond, Clock: skewed}, true},
+		{"zero TTL", lock.Config{}, false},
+		{"negative TTL", lock.Config{TTL: -time.Second}, false},
+		{"negative renewal", lock.Config{TTL: time.Second, RenewEvery: -time.Millisecond}, false},
+		{"renewal after the lease is given up", lock.Config{TTL: time.Second, RenewEvery: 700 * time.Millisecond, Clock: skewed}, false},
+		{"renewal after the TTL", lock.Config{TTL: time.Second, RenewEvery: 2 * time.Second}, false},
+		{"uncertainty longer than the TTL", lock.Config{TTL: 100 * time.Millisecond, Clock: skewed}, false},
+	} {
+		if _, err := lock.New(newMemKV().node(), "n1", c.cfg); (err == nil) != c.ok {
+			t.Errorf("%s: err=%v, want ok=%v", c.name, err, c.ok)
+		}
+	}
+}
+
+// TestLock_MutualExclusion has several lockers contend for one lock, and
+// checks that no two hold it at once and that fencing tokens only increase.
+func TestLock_MutualExclusion(t *testing.T) {
+	const owners, rounds = 5, 10
+
+	kv := newMemKV()
+	var (
+		holders   atomic.Int32
+		mu        sync.Mutex
+		lastToken int64
+		wg        sync.WaitGroup
+	)
+	for i := 0; i < owners; i++ {
+		l := newLocker(t, kv.node(), fmt.Sprintf("n%d", i), lock.Config{TTL: time.Second})
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < rounds; j++ {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				lease, err := l.Acquire(ctx, "log")
+				cancel()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				if n := holders.Add(1); n != 1 {
+					t.Errorf("%d holders at once", n)
+				}
+				mu.Lock()
+				if lease.Token() <= lastToken {
+					t.Errorf("token %d after %d", lease.Token(), lastToken)
+				}
+				lastToken = lease.Token()
+				mu.Unlock()
+				time.Sleep(time.Millisecond)
+				holders.Add(-1)
+
+				if err := lease.Release(context.Background()); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if lastToken != owners*rounds {
+		t.Fatalf("last token=%d, want %d", lastToken, owners*rounds)
+	}
+}
+
+// TestLock_Renewal holds a lock for several TTLs and checks that it is kept.
+func TestLock_Renewal(t *testing.T) {
+	kv := newMemKV()
+	cfg := lock.Config{TTL: 100 * time.Millisecond}
+	a, b := newLocker(t, kv.node(), "n1", cfg), newLocker(t, kv.node(), "n2", cfg)
+
+	lease, err := a.TryAcquire(context.Background(), "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		time.Sleep(cfg.TTL)
+		if _, err := b.TryAcquire(context.Background(), "log"); !errors.Is(err, lock.ErrHeld) {
+			t.Fatalf("after %d TTLs: unexpected error: %v", i+1, err)
+		}
+		if err := lease.Context().Err(); err != nil {
+			t.Fatalf("after %d TTLs: lease lost: %v", i+1, err)
+		}
+	}
+
+	// Locks aren't reentrant.
+	if _, err := a.TryAcquire(context.Background(), "log"); !errors.Is(err, lock.ErrHeld) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Releasing cancels the context and frees the lock at once.
+	if err := lease.Release(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if lease.Context().Err() == nil {
+		t.Fatal("context not cancelled by Release")
+	}
+	next, err := b.TryAcquire(context.Background(), "log")
+	if err != nil {
+		t.Fatal(err)
+	} else if next.Token() != lease.Token()+1 {
+		t.Fatalf("token=%d, want %d", next.Token(), lease.Token()+1)
+	}
+	next.Release(context.Background())
+}
+
+// TestLock_Partition cuts the holder off from the store and checks that its
+// context is cancelled before another owner can take the lock over.
+func TestLock_Partition(t *testing.T) {
+	kv := newMemKV()
+	cfg := lock.Config{TTL: 200 * time.Millisecond, Clock: lock.LocalClock{MaxSkew: 20 * time.Millisecond}}
+	holderKV := kv.node()
+	holder, other := newLocker(t, holderKV, "n1", cfg), newLocker(t, kv.node(), "n2", cfg)
+
+	lease, err := holder.TryAcquire(context.Background(), "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	holderKV.cut.Store(true)
+
+	lost := make(chan time.Time, 1)
+	go func() {
+		<-lease.Context().Done()
+		lost <- time.Now()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	next, err := other.Acquire(ctx, "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acquiredAt := time.Now()
+
+	select {
+	case lostAt := <-lost:
+		if !lostAt.Before(acquiredAt) {
+			t.Fatalf("lease lost at %v, taken over at %v", lostAt, acquiredAt)
+		}
+	default:
+		t.Fatal("lease taken over before it was lost")
+	}
+	if next.Token() <= lease.Token() {
+		t.Fatalf("token=%d after %d", next.Token(), lease.Token())
+	}
+
+	// Once the partition heals, the old holder finds its lease gone.
+	holderKV.cut.Store(false)
+	if err := lease.Release(context.Background()); !errors.Is(err, lock.ErrLost) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	next.Release(context.Background())
+}
+
+// testOracle is a timestamp oracle reading the wall clock, never going back.
+type testOracle struct {
+	mu    sync.Mutex
+	last  int64
+	calls int
+}
+
+func (o *testOracle) Timestamp(context.Context) (int64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.calls++
+	if now := time.Now().UnixMilli(); now > o.last {
+		o.last = now
+	}
+	return o.last, nil
+}
+
+func TestLock_TSOClock(t *testing.T) {
+	kv := newMemKV()
+	oracle := &testOracle{}
+	cfg := lock.Config{TTL: 100 * time.Millisecond, Clock: lock.TSOClock{Oracle: oracle}}
+	a, b := newLocker(t, kv.node(), "n1", cfg), newLocker(t, kv.node(), "n2", cfg)
+
+	lease, err := a.TryAcquire(context.Background(), "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * cfg.TTL)
+	if _, err := b.TryAcquire(context.Background(), "log"); !errors.Is(err, lock.ErrHeld) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := lease.Release(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Acquiring, renewing and checking all read the oracle.
+	oracle.mu.Lock()
+	defer oracle.mu.Unlock()
+	if oracle.calls < 4 {
+		t.Fatalf("oracle read %d times, want at least 4", oracle.calls)
+	}
+}
+
+func TestLock_NotALock(t *testing.T) {
+	kv := newMemKV()
+	n := kv.node()
+	if err := n.CompareAndSwap(context.Background(), "lock-log", nil, "text", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newLocker(t, n, "n1", lock.Config{TTL: time.Second}).TryAcquire(context.Background(), "log"); err == nil {
+		t.Fatal("acquired a lock whose key holds a string")
+	}
+}
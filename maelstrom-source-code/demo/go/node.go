@@ -2,6 +2,7 @@ package maelstrom
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -191,10 +192,8 @@ func (n *Node) Reply(req Message, body any) error {
 	}
 
 	// We have to marshal/unmarshal to inject our reply message ID.
-	b := make(map[string]any)
-	if buf, err := json.Marshal(body); err != nil {
-		return err
-	} else if err := json.Unmarshal(buf, &b); err != nil {
+	b, err := bodyMap(body, false)
+	if err != nil {
 		return err
 	}
 	b["in_reply_to"] = reqBody.MsgID
@@ -202,6 +201,27 @@ func (n *Node) Reply(req Message, body any) error {
 	return n.Send(req.Src, b)
 }
 
+// bodyMap marshals body and unmarshals it into a map, so fields can be added
+// to it. Numbers become float64, as they always have for Reply and RPC,
+// unless exact is set, in which case they are kept as json.Number so that
+// integers too large for a float64 are sent unchanged.
+func bodyMap(body any, exact bool) (map[string]any, error) {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make(map[string]any)
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	if exact {
+		dec.UseNumber()
+	}
+	if err := dec.Decode(&b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
 // Send sends a message body to a given destination node.
 func (n *Node) Send(dest string, body any) error {
 	bodyJSON, err := json.Marshal(body)
@@ -233,6 +253,11 @@ func (n *Node) Send(dest string, body any) error {
 
 // RPC sends an async RPC request. Handler invoked when response message received.
 func (n *Node) RPC(dest string, body any, handler HandlerFunc) error {
+	return n.rpc(dest, body, handler, false)
+}
+
+// rpc is RPC, sending the numbers in body exactly if exact is set; see bodyMap.
+func (n *Node) rpc(dest string, body any, handler HandlerFunc, exact bool) error {
 	n.mu.Lock()
 
 	// Generate a unique message ID.
@@ -245,10 +270,8 @@ func (n *Node) RPC(dest string, body any, handler HandlerFunc) error {
 	n.mu.Unlock()
 
 	// We have to marshal/unmarshal to inject our message ID.
-	b := make(map[string]any)
-	if buf, err := json.Marshal(body); err != nil {
-		return err
-	} else if err := json.Unmarshal(buf, &b); err != nil {
+	b, err := bodyMap(body, exact)
+	if err != nil {
 		return err
 	}
 	b["msg_id"] = msgID
@@ -259,11 +282,21 @@ func (n *Node) RPC(dest string, body any, handler HandlerFunc) error {
 // SyncRPC sends a synchronous RPC request. Returns the response message. RPC
 // errors in the message body are converted to *RPCError and are returned.
 func (n *Node) SyncRPC(ctx context.Context, dest string, body any) (Message, error) {
-	respCh := make(chan Message)
-	if err := n.RPC(dest, body, func(m Message) error {
+	return n.syncRPC(ctx, dest, body, false)
+}
+
+// syncRPC is SyncRPC, sending the numbers in body exactly if exact is set;
+// see bodyMap.
+func (n *Node) syncRPC(ctx context.Context, dest string, body any, exact bool) (Message, error) {
+	// The channel is buffered because the callback may run after ctx is done
+	// and nothing is left to receive from it. Unbuffered, the callback would
+	// block forever, leaking its goroutine, and Run, which waits for every
+	// callback before it returns, would never return.
+	respCh := make(chan Message, 1)
+	if err := n.rpc(dest, body, func(m Message) error {
 		respCh <- m
 		return nil
-	}); err != nil {
+	}, exact); err != nil {
 		return Message{}, err
 	}
 
@@ -293,6 +293,32 @@ func TestNode_SyncRPC(t *testing.T) {
 		}
 	})
 
+	// Ensure a response that arrives after the context is done doesn't block
+	// the node: newNode fails the test if Run doesn't return once stdin closes.
+	t.Run("LateResponse", func(t *testing.T) {
+		n, stdin, stdout := newNode(t)
+		initNode(t, n, "n1", []string{"n1", "n2"}, stdin, stdout)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errorCh := make(chan error)
+		go func() {
+			_, err := n.SyncRPC(ctx, "n2", map[string]any{"type": "foo"})
+			errorCh <- err
+		}()
+		if _, err := stdout.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+
+		cancel()
+		if err := <-errorCh; !errors.Is(err, context.Canceled) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, err := stdin.Write([]byte(`{"src":"n2", "dest":"n1", "body":{"type":"foo_ok", "msg_id":2, "in_reply_to":1}}` + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	})
+
 	t.Run("RPCError", func(t *testing.T) {
 		n, stdin, stdout := newNode(t)
 		initNode(t, n, "n1", []string{"n1", "n2"}, stdin, stdout)
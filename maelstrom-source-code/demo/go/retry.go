@@ -0,0 +1,59 @@
+package maelstrom
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy bounds how long an operation keeps retrying after losing a
+// compare-and-swap race.
+type RetryPolicy struct {
+	MaxAttempts int           // Attempts before giving up.
+	BaseDelay   time.Duration // Longest wait after the first attempt.
+	MaxDelay    time.Duration // Longest wait after any attempt.
+}
+
+// DefaultRetryPolicy allows 20 attempts. Waiting the longest possible time
+// after each of the first 19 adds up to about 0.7s, and the waits are
+// random, so an update that loses every race typically gives up after half
+// that: well inside a one-second request timeout.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 20,
+	BaseDelay:   time.Millisecond,
+	MaxDelay:    50 * time.Millisecond,
+}
+
+// Backoff waits before the attempt after the given one. The wait is random,
+// so that racing nodes spread out, and at most p.ceiling(attempt). It returns
+// ctx's error if ctx is done first.
+func (p RetryPolicy) Backoff(ctx context.Context, attempt int) error {
+	ceiling := p.ceiling(attempt)
+	if ceiling <= 0 {
+		return ctx.Err()
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Duration(rand.Int63n(int64(ceiling) + 1))):
+		return nil
+	}
+}
+
+// ceiling returns the longest wait after the given attempt: BaseDelay,
+// doubled for every attempt before it, but never more than MaxDelay.
+func (p RetryPolicy) ceiling(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && d > 0 && d < p.MaxDelay; i++ {
+		if d > p.MaxDelay/2 {
+			d = p.MaxDelay
+		} else {
+			d *= 2
+		}
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
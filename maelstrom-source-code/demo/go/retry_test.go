@@ -0,0 +1,44 @@
+package maelstrom_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	t.Run("Capped", func(t *testing.T) {
+		p := maelstrom.RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+		// Late attempts, even ones whose doubled delay would overflow, wait
+		// no longer than MaxDelay.
+		start := time.Now()
+		for _, attempt := range []int{10, 64, 1 << 30} {
+			if err := p.Backoff(context.Background(), attempt); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("3 backoffs took %s, want at most 15ms plus scheduling", elapsed)
+		}
+	})
+
+	t.Run("ZeroDelay", func(t *testing.T) {
+		if err := (maelstrom.RetryPolicy{}).Backoff(context.Background(), 1<<30); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("ErrContextDone", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		p := maelstrom.RetryPolicy{BaseDelay: time.Hour, MaxDelay: time.Hour}
+		if err := p.Backoff(ctx, 1); !errors.Is(err, context.Canceled) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package maelstrom
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrValueType is wrapped by the errors TypedKV returns when a stored value
+// does not match the type it is read as.
+var ErrValueType = errors.New("value does not match type")
+
+// TypedKV is a client to the key/value store whose values are of type T.
+//
+// Values are decoded straight from the response into T, with numbers as
+// json.Number, so no precision is lost: an int64 or a json.Number reads back
+// exactly as written, and a T of any holds json.Number rather than float64.
+// A value that does not fit T, such as a fraction read as an int or an
+// object with fields T lacks, is an error wrapping ErrValueType.
+type TypedKV[T any] struct {
+	kv *KV
+}
+
+// NewTypedKV returns a client for values of type T that sends its requests
+// through kv.
+func NewTypedKV[T any](kv *KV) *TypedKV[T] {
+	return &TypedKV[T]{kv: kv}
+}
+
+// Read returns the value for a given key in the key/value store.
+// Returns an *RPCError error with a KeyDoesNotExist code if the key does not
+// exist, and an error wrapping ErrValueType if its value does not match T.
+func (kv *TypedKV[T]) Read(ctx context.Context, key string) (T, error) {
+	var v T
+
+	resp, err := kv.kv.read(ctx, key)
+	if err != nil {
+		return v, err
+	}
+
+	var body struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		return v, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body.Value))
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&v); err != nil {
+		return v, fmt.Errorf("%w: key %q holds %s, want %T: %s", ErrValueType, key, body.Value, v, err)
+	}
+	return v, nil
+}
+
+// Write overwrites the value for a given key in the key/value store. Numbers
+// in value are sent exactly, unlike KV.Write, which sends them as float64.
+func (kv *TypedKV[T]) Write(ctx context.Context, key string, value T) error {
+	return kv.kv.write(ctx, key, value, true)
+}
+
+// CompareAndSwap updates the value for a key if its current value matches the
+// previous value. Creates the key if createIfNotExists is true.
+//
+// Numbers in from and to are sent exactly, as by Write.
+//
+// Returns an *RPCError with a code of PreconditionFailed if the previous value
+// does not match. Return a code of KeyDoesNotExist if the key did not exist.
+func (kv *TypedKV[T]) CompareAndSwap(ctx context.Context, key string, from, to T, createIfNotExists bool) error {
+	return kv.kv.compareAndSwap(ctx, key, from, to, createIfNotExists, true)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"maelstrom-unique-ids/idgen"
+)
+
+/*
+runInspect implements the inspect command, which decodes IDs given on the command line.
+
+Parameters:
+  - args: the command's arguments: an optional -nodes flag, the cluster's node IDs separated by
+    commas in the order Maelstrom sends them, followed by the IDs. A snowflake ID is written in
+    decimal.
+  - stdout: receives one line per ID: its fields as JSON, or why it couldn't be decoded.
+
+Returns:
+  - An error if the arguments are invalid, or if any ID couldn't be decoded.
+*/
+func runInspect(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	nodes := flags.String("nodes", "", "the cluster's node IDs, in order, separated by commas; if set, node indexes are checked and resolved")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: inspect [-nodes n0,n1,...] ID...")
+	}
+
+	var ids []string
+	if *nodes != "" {
+		ids = strings.Split(*nodes, ",")
+	}
+
+	failed := 0
+	for _, id := range flags.Args() {
+		d, err := idgen.Decode(id, ids, time.Now())
+		if err != nil {
+			fmt.Fprintf(stdout, "%s: %v\n", id, err)
+			failed++
+			continue
+		}
+
+		out, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s\n", out)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d IDs couldn't be decoded", failed, flags.NArg())
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"maelstrom-unique-ids/idgen"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// formats lists the ID formats a generate request may ask for.
+var formats = append([]string{"guid", "dense"}, idgen.TimestampedFormats...)
+
+// denseKey is the lin-kv key holding the highest dense ID claimed by any node.
+const denseKey = "dense-ids"
+
+// generators holds a node's generator for each of formats.
+type generators map[string]idgen.Generator
+
+/*
+newGenerators returns the generators of a node.
+
+Parameters:
+  - id: the node's ID.
+  - ids: the IDs of every node in the cluster, in the order every node receives them.
+  - kv: the linearizable store that dense IDs are claimed from.
+  - cfg: the server's configuration.
+
+Returns:
+  - The generators. The timestamped formats share one idgen.Sequencer.
+  - An error if id is not in ids, or the dense block sizes are invalid.
+*/
+func newGenerators(id string, ids []string, kv idgen.KV, cfg config) (generators, error) {
+	index, err := idgen.NodeIndex(id, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	dense, err := idgen.NewBlock(kv, denseKey, idgen.BlockConfig{
+		MinSize:  cfg.blockMin,
+		MaxSize:  cfg.blockMax,
+		Lifetime: cfg.blockLifetime,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	gens := generators{"guid": idgen.NewGUID(id), "dense": dense}
+	seq := idgen.NewSequencer(idgen.SequencerConfig{Borrow: cfg.borrow, MaxRegression: cfg.maxRegression})
+	for _, format := range idgen.TimestampedFormats {
+		g, err := idgen.NewTimestamped(format, index, seq)
+		if err != nil {
+			// The cluster is too large for the format; the others still work.
+			gens[format] = unsupported{maelstrom.NewRPCError(maelstrom.NotSupported, err.Error())}
+			continue
+		}
+		gens[format] = g
+	}
+	return gens, nil
+}
+
+// next returns count new IDs in format, or an *maelstrom.RPCError with code MalformedRequest if
+// the format is unknown.
+func (g generators) next(ctx context.Context, format string, count int) ([]any, error) {
+	gen, ok := g[format]
+	if !ok {
+		return nil, maelstrom.NewRPCError(maelstrom.MalformedRequest,
+			fmt.Sprintf("unknown format %q, want one of %v", format, formats))
+	}
+	return gen.Next(ctx, count)
+}
+
+// unsupported is an idgen.Generator that always fails with err.
+type unsupported struct {
+	err error
+}
+
+// Next implements idgen.Generator.
+func (u unsupported) Next(context.Context, int) ([]any, error) {
+	return nil, u.err
+}
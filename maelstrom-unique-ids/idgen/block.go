@@ -0,0 +1,184 @@
+package idgen
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// KV is the part of a Maelstrom key-value client, such as *maelstrom.KV, that Block uses.
+type KV interface {
+	Read(ctx context.Context, key string) (any, error)
+	CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error
+}
+
+/*
+Block hands out small, dense integer IDs. A shared key holds the highest ID claimed by any node.
+A node claims the next block of IDs by compare-and-swapping the key forward, then hands the block
+out locally without further requests. The key must be in a linearizable store such as lin-kv:
+on a weaker one two nodes may claim the same block.
+
+The size of a block adapts to the rate the node hands IDs out at, so that a block lasts about
+Lifetime: it doubles when a block ran out sooner than half that, and halves when one lasted more
+than twice that. Busy nodes then claim rarely, and idle ones leave small gaps when they stop.
+
+IDs start at 1. The only gaps are the unused ends of the blocks of nodes that stopped, and blocks
+whose claim timed out after the store applied it.
+
+During a partition a node keeps handing out the rest of its blocks, but once they run out it
+refuses to issue IDs until it can claim another: it never issues an ID it hasn't claimed.
+*/
+type Block struct {
+	kv  KV
+	key string
+	cfg BlockConfig
+
+	mu      sync.Mutex // mu guards access to the fields below.
+	blocks  []span     // The claimed IDs not yet handed out, oldest first.
+	size    int        // How many IDs to claim next.
+	claimed time.Time  // When the last block was claimed.
+}
+
+// BlockConfig configures a Block.
+type BlockConfig struct {
+	MinSize  int              // The fewest IDs to claim at once, at least 1.
+	MaxSize  int              // The most IDs to claim at once, unless a batch needs more.
+	Lifetime time.Duration    // How long a block should last; 0 keeps the size at MinSize.
+	Now      func() time.Time // The clock; nil for time.Now.
+}
+
+// span is a range of claimed IDs, from next up to but excluding end.
+type span struct {
+	next, end int64
+}
+
+/*
+NewBlock initializes and returns a pointer to a new Block generator.
+
+Parameters:
+  - kv: the linearizable store holding the key.
+  - key: the key holding the highest ID claimed. It may not exist yet.
+  - cfg: the block sizes.
+
+Returns:
+  - The generator.
+  - An error if the sizes aren't positive or MaxSize is less than MinSize.
+*/
+func NewBlock(kv KV, key string, cfg BlockConfig) (*Block, error) {
+	if cfg.MinSize < 1 || cfg.MaxSize < cfg.MinSize {
+		return nil, fmt.Errorf("block sizes must satisfy 1 <= min <= max, got min %d and max %d", cfg.MinSize, cfg.MaxSize)
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Block{kv: kv, key: key, cfg: cfg, size: cfg.MinSize}, nil
+}
+
+/*
+Next implements Generator. If the claimed IDs don't cover count, it first claims enough, retrying
+until it wins or ctx expires, which holds up the node's other batches.
+
+If a block can't be claimed, it fails with TemporarilyUnavailable and hands out nothing: the IDs
+it did claim are kept for later batches.
+*/
+func (b *Block) Next(ctx context.Context, count int) ([]any, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	available := 0
+	for _, s := range b.blocks {
+		available += int(s.end - s.next)
+	}
+	for available < count {
+		s, err := b.claim(ctx, count-available)
+		if err != nil {
+			return nil, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable,
+				fmt.Sprintf("no IDs left and can't claim more: %v", err))
+		}
+		b.blocks = append(b.blocks, s)
+		available += int(s.end - s.next)
+	}
+
+	ids := make([]any, 0, count)
+	for len(ids) < count {
+		s := &b.blocks[0]
+		ids = append(ids, s.next)
+		if s.next++; s.next == s.end {
+			b.blocks = b.blocks[1:]
+		}
+	}
+	return ids, nil
+}
+
+// claim claims a block of at least need IDs. The caller must hold b.mu.
+func (b *Block) claim(ctx context.Context, need int) (span, error) {
+	b.resize()
+	size := max(b.size, need)
+
+	for attempt := 1; ; attempt++ {
+		var claimed int64
+		v, err := b.kv.Read(ctx, b.key)
+		if rpcErr, ok := err.(*maelstrom.RPCError); ok && rpcErr.Code == maelstrom.KeyDoesNotExist {
+			claimed = 0
+		} else if err != nil {
+			return span{}, err
+		} else if n, ok := v.(int); ok {
+			claimed = int64(n)
+		} else {
+			return span{}, fmt.Errorf("block key %s holds %v (%T), not an integer", b.key, v, v)
+		}
+
+		// If this times out, the store may still apply it. The block is then lost, but never
+		// handed out twice: the next attempt reads the key again.
+		err = b.kv.CompareAndSwap(ctx, b.key, claimed, claimed+int64(size), true)
+		if rpcErr, ok := err.(*maelstrom.RPCError); ok && rpcErr.Code == maelstrom.PreconditionFailed {
+			// Another node claimed a block first.
+			if err := backoff(ctx, attempt); err != nil {
+				return span{}, err
+			}
+			continue
+		} else if err != nil {
+			return span{}, err
+		}
+
+		b.claimed = b.cfg.Now()
+		return span{next: claimed + 1, end: claimed + int64(size) + 1}, nil
+	}
+}
+
+// resize adapts the block size to how long the last block lasted. The caller must hold b.mu.
+func (b *Block) resize() {
+	if b.cfg.Lifetime == 0 || b.claimed.IsZero() {
+		return
+	}
+
+	switch lasted := b.cfg.Now().Sub(b.claimed); {
+	case lasted < b.cfg.Lifetime/2:
+		b.size = min(2*b.size, b.cfg.MaxSize)
+	case lasted > 2*b.cfg.Lifetime:
+		b.size = max(b.size/2, b.cfg.MinSize)
+	}
+}
+
+// backoff sleeps for a random duration of up to 2^(attempt-1) milliseconds, capped at 50ms, or
+// until ctx expires, in which case it returns ctx's error.
+func backoff(ctx context.Context, attempt int) error {
+	bound := 50 * time.Millisecond
+	if attempt-1 < 6 {
+		bound = time.Millisecond << (attempt - 1)
+	}
+
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(bound) + 1)))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,173 @@
+package idgen
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxFuture is how far past the decoder's clock an ID's timestamp may be. Borrowing may run a
+// node's timestamps ahead of its clock, and clocks differ, but not by this much.
+const maxFuture = time.Minute
+
+// Decoded holds the fields of an ID, as returned by Decode.
+type Decoded struct {
+	Format    string    `json:"format"`
+	Node      string    `json:"node,omitempty"` // The ID of the node that generated it, if known.
+	NodeIndex int       `json:"node_index"`     // -1 for GUIDs, which hold the node's ID instead.
+	Time      time.Time `json:"time"`
+	Sequence  uint64    `json:"sequence"`         // The per-millisecond sequence, or a GUID's counter.
+	Random    string    `json:"random,omitempty"` // The random bits, in hexadecimal.
+}
+
+var (
+	guidPattern   = regexp.MustCompile(`^(.+)_(0|[1-9][0-9]*)_([1-9][0-9]*)_([0-9a-f]{16})$`)
+	ulidPattern   = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+	uuidv7Pattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+)
+
+/*
+Decode parses an ID of any format back into its fields.
+
+Parameters:
+  - id: the ID. A snowflake ID may be a json.Number, an int64 or a string of digits; the other
+    formats are strings. A float64 is rejected, since it can't hold every snowflake ID exactly.
+  - nodes: the IDs of every node in the cluster, in the order every node receives them, or nil if
+    unknown. Node indexes are then neither checked nor resolved to node IDs.
+  - now: the current time.
+
+Returns:
+  - The ID's fields.
+  - An error if the ID isn't well formed in any format, or couldn't have been generated by this
+    cluster: its node isn't in nodes, or its timestamp isn't after SnowflakeEpoch or is more than
+    maxFuture after now. A snowflake ID below 2^22, such as a dense ID, has a timestamp of
+    SnowflakeEpoch itself and so is rejected rather than decoded as a snowflake.
+*/
+func Decode(id any, nodes []string, now time.Time) (Decoded, error) {
+	var d Decoded
+	var err error
+
+	switch v := id.(type) {
+	case int64:
+		d, err = decodeSnowflake(v)
+	case json.Number:
+		d, err = decodeSnowflakeString(string(v))
+	case string:
+		switch {
+		case ulidPattern.MatchString(strings.ToUpper(v)):
+			d = decodeULID(strings.ToUpper(v))
+		case uuidv7Pattern.MatchString(v):
+			d = decodeUUIDv7(v)
+		case guidPattern.MatchString(v):
+			d, err = decodeGUID(v)
+		default:
+			d, err = decodeSnowflakeString(v)
+		}
+	default:
+		return Decoded{}, fmt.Errorf("an ID is a string or an integer, got %T", id)
+	}
+	if err != nil {
+		return Decoded{}, err
+	}
+
+	if !d.Time.After(SnowflakeEpoch) {
+		return Decoded{}, fmt.Errorf("%s timestamp %v predates every ID generated here", d.Format, d.Time)
+	}
+	if d.Time.After(now.Add(maxFuture)) {
+		return Decoded{}, fmt.Errorf("%s timestamp %v is in the future", d.Format, d.Time)
+	}
+
+	if nodes != nil {
+		if d.NodeIndex < 0 {
+			if _, err := NodeIndex(d.Node, nodes); err != nil {
+				return Decoded{}, err
+			}
+		} else if d.NodeIndex >= len(nodes) {
+			return Decoded{}, fmt.Errorf("node index %d, but the cluster has %d nodes", d.NodeIndex, len(nodes))
+		} else {
+			d.Node = nodes[d.NodeIndex]
+		}
+	}
+	return d, nil
+}
+
+// decodeSnowflakeString decodes a snowflake ID written in decimal.
+func decodeSnowflakeString(s string) (Decoded, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return Decoded{}, fmt.Errorf("%q is not an ID in any format", s)
+	}
+	return decodeSnowflake(id)
+}
+
+// decodeSnowflake decodes a snowflake ID; see EncodeSnowflake.
+func decodeSnowflake(id int64) (Decoded, error) {
+	if id < 0 {
+		return Decoded{}, fmt.Errorf("snowflake ID %d is negative", id)
+	}
+	return Decoded{
+		Format:    "snowflake",
+		NodeIndex: int(id >> 12 & (MaxNodes - 1)),
+		Time:      time.UnixMilli(id>>(nodeBits+12) + SnowflakeEpoch.UnixMilli()).UTC(),
+		Sequence:  uint64(id & MaxSequence),
+	}, nil
+}
+
+// decodeULID decodes a ULID that matches ulidPattern; see EncodeULID.
+func decodeULID(s string) Decoded {
+	var hi, lo uint64
+	for i := 0; i < len(s); i++ {
+		hi = hi<<5 | lo>>59
+		lo = lo<<5 | uint64(strings.IndexByte(crockford, s[i]))
+	}
+
+	return Decoded{
+		Format:    "ulid",
+		NodeIndex: int(hi >> 6 & (MaxNodes - 1)),
+		Time:      time.UnixMilli(int64(hi >> 16)).UTC(),
+		Sequence:  (hi&(1<<6-1))<<6 | lo>>58,
+		Random:    fmt.Sprintf("%015x", lo&(1<<58-1)),
+	}
+}
+
+// decodeUUIDv7 decodes a version 7 UUID that matches uuidv7Pattern; see EncodeUUIDv7.
+func decodeUUIDv7(s string) Decoded {
+	b, _ := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+
+	return Decoded{
+		Format:    "uuidv7",
+		NodeIndex: int(lo >> 52 & (MaxNodes - 1)),
+		Time:      time.UnixMilli(int64(hi >> 16)).UTC(),
+		Sequence:  hi & MaxSequence,
+		Random:    fmt.Sprintf("%013x", lo&(1<<52-1)),
+	}
+}
+
+// decodeGUID decodes a GUID that matches guidPattern; see createGUID.
+func decodeGUID(s string) (Decoded, error) {
+	m := guidPattern.FindStringSubmatch(s)
+
+	counter, err := strconv.ParseUint(m[2], 10, 64)
+	if err != nil {
+		return Decoded{}, fmt.Errorf("GUID counter %s: %w", m[2], err)
+	}
+	nanos, err := strconv.ParseInt(m[3], 10, 64)
+	if err != nil {
+		return Decoded{}, fmt.Errorf("GUID timestamp %s: %w", m[3], err)
+	}
+
+	return Decoded{
+		Format:    "guid",
+		Node:      m[1],
+		NodeIndex: -1,
+		Time:      time.Unix(0, nanos).UTC(),
+		Sequence:  counter,
+		Random:    m[4],
+	}, nil
+}
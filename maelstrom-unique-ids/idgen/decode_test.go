@@ -0,0 +1,102 @@
+package idgen_test
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"maelstrom-unique-ids/idgen"
+)
+
+// TestDecode_RoundTrip decodes IDs of every format and checks that their fields are the ones
+// they were generated with.
+func TestDecode_RoundTrip(t *testing.T) {
+	nodes := []string{"n0", "n1", "n2"}
+
+	for index, node := range nodes {
+		seq := idgen.NewSequencer(idgen.SequencerConfig{MaxRegression: time.Second})
+		gens := map[string]idgen.Generator{"guid": idgen.NewGUID(node)}
+		for _, format := range idgen.TimestampedFormats {
+			gens[format] = newTimestamped(t, format, index, seq)
+		}
+
+		for format, g := range gens {
+			before := time.Now().Truncate(time.Millisecond)
+			ids, err := g.Next(context.Background(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			after := time.Now()
+
+			id := ids[0]
+			d, err := idgen.Decode(id, nodes, time.Now())
+			if err != nil {
+				t.Fatalf("Decode(%v): %v", id, err)
+			}
+
+			if d.Format != format || d.Node != node || d.Time.Before(before) || d.Time.After(after) {
+				t.Errorf("Decode(%v) = %+v, want format %s, node %s and a time in [%v, %v]",
+					id, d, format, node, before, after)
+			}
+			if format != "guid" && d.NodeIndex != index {
+				t.Errorf("Decode(%v) has node index %d, want %d", id, d.NodeIndex, index)
+			}
+		}
+	}
+
+	// The sequence and random bits come back too.
+	ms := time.Date(2025, time.June, 1, 12, 0, 0, 0, time.UTC).UnixMilli()
+	for _, id := range []string{idgen.EncodeULID(ms, 7, 1234, 0xabcdef), idgen.EncodeUUIDv7(ms, 7, 1234, 0xabcdef)} {
+		d, err := idgen.Decode(id, nil, time.Now())
+		if err != nil {
+			t.Fatalf("Decode(%s): %v", id, err)
+		}
+		if d.NodeIndex != 7 || d.Node != "" || d.Sequence != 1234 || !strings.HasSuffix(d.Random, "abcdef") || d.Time.UnixMilli() != ms {
+			t.Errorf("Decode(%s) = %+v", id, d)
+		}
+	}
+
+	// Snowflake IDs decode from JSON without losing precision, and from decimal strings.
+	id := idgen.EncodeSnowflake(ms, 2, 4095)
+	for _, v := range []any{json.Number(strconv.FormatInt(id, 10)), strconv.FormatInt(id, 10)} {
+		if d, err := idgen.Decode(v, nodes, time.Now()); err != nil || d.Sequence != 4095 || d.Node != "n2" {
+			t.Errorf("Decode(%#v) = %+v, %v", v, d, err)
+		}
+	}
+}
+
+// TestDecode_Rejects checks that malformed IDs, and IDs this cluster couldn't have generated,
+// are rejected.
+func TestDecode_Rejects(t *testing.T) {
+	nodes := []string{"n0", "n1", "n2"}
+	now := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ms := now.Add(-time.Hour).UnixMilli()
+
+	for name, id := range map[string]any{
+		"empty":                    "",
+		"float":                    1.5e17,
+		"bool":                     true,
+		"truncated ULID":           idgen.EncodeULID(ms, 1, 0, 0)[:25],
+		"ULID with a U":            "U" + idgen.EncodeULID(ms, 1, 0, 0)[1:],
+		"ULID overflowing 128 bit": "8" + idgen.EncodeULID(ms, 1, 0, 0)[1:],
+		"UUID version 4":           strings.Replace(idgen.EncodeUUIDv7(ms, 1, 0, 0), "-7", "-4", 1),
+		"UUID variant":             idgen.EncodeUUIDv7(ms, 1, 0, 0)[:19] + "c" + idgen.EncodeUUIDv7(ms, 1, 0, 0)[20:],
+		"GUID with short random":   "n1_0_1767225600000000000_abc",
+		"negative snowflake":       "-1",
+		"future ULID":              idgen.EncodeULID(now.Add(time.Hour).UnixMilli(), 1, 0, 0),
+		"future snowflake":         idgen.EncodeSnowflake(now.Add(time.Hour).UnixMilli(), 1, 0),
+		"ancient UUID":             idgen.EncodeUUIDv7(time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC).UnixMilli(), 1, 0, 0),
+		"epoch snowflake":          idgen.EncodeSnowflake(idgen.SnowflakeEpoch.UnixMilli(), 1, 0),
+		"small integer":            json.Number("42"),
+		"largest at the epoch":     int64(1<<22 - 1),
+		"unknown node index":       idgen.EncodeULID(ms, 3, 0, 0),
+		"unknown GUID node":        "n9_0_" + strconv.FormatInt(now.UnixNano(), 10) + "_0123456789abcdef",
+	} {
+		if d, err := idgen.Decode(id, nodes, now); err == nil {
+			t.Errorf("%s: Decode(%v) = %+v, want an error", name, id, d)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package idgen
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// GUID generates Globally Unique Identifiers (GUIDs) by combining the node's ID, an atomic
+// counter, the current timestamp in nanoseconds, and 64 randomly generated bits. It needs no
+// coordination and works on any number of nodes.
+type GUID struct {
+	nodeID string
+	count  atomic.Uint64 // Counts the GUIDs generated.
+}
+
+// NewGUID initializes and returns a pointer to a new GUID generator for the node nodeID.
+func NewGUID(nodeID string) *GUID {
+	return &GUID{nodeID: nodeID}
+}
+
+// Next implements Generator. It never fails, and never locks: the batch's counter values are
+// reserved at once.
+func (g *GUID) Next(_ context.Context, count int) ([]any, error) {
+	// Atomically reserve count values of the GUID counter.
+	first := reserveCounts(&g.count, count)
+
+	// Create unique GUIDs by combining the node ID, the atomic counter, the timestamp, and random bytes.
+	ids := make([]any, count)
+	for i := range ids {
+		ids[i] = createGUID(g.nodeID, first+uint64(i))
+	}
+	return ids, nil
+}
+
+// generateRandomBytes generates 8 random bytes to ensure uniqueness for the GUID.
+func generateRandomBytes() []byte {
+	randomBytes := make([]byte, 8)
+	_, err := rand.Read(randomBytes)
+
+	if err != nil {
+		log.Fatal("Error generating random bytes:", err)
+	}
+
+	return randomBytes
+}
+
+// createGUID creates a Globally Unique Identifier (GUID) based on the node's ID,
+// an atomic counter, the current timestamp, and 64 random bits to ensure uniqueness.
+func createGUID(nodeID string, id uint64) string {
+	// Combine nodeID and ID to create a base unique key.
+	// Example: For node n1, the ID could be n1_0; for node n2, it could be n2_0.
+	uniqueID := nodeID + "_" + strconv.FormatUint(id, 10)
+
+	// Append the current timestamp in nanoseconds to further ensure uniqueness during runtime.
+	currentTime := uint64(time.Now().UnixNano())
+	uniqueID += "_" + strconv.FormatUint(currentTime, 10)
+
+	// Generate random bytes and convert them to a hexadecimal string to prevent collisions,
+	// even in cases where the timestamp might overflow.
+	randomBytes := generateRandomBytes()
+	randomString := fmt.Sprintf("%x", randomBytes)
+
+	uniqueID += "_" + randomString
+
+	return uniqueID
+}
+
+// reserveCounts atomically advances the GUID counter by count, handles overflow, and returns
+// the first of the count values reserved.
+func reserveCounts(counter *atomic.Uint64, count int) uint64 {
+	for {
+		prev := counter.Load()
+		first, next := prev, prev+uint64(count)
+
+		// If the GUID counter would reach the maximum value, reset it to zero to prevent overflow.
+		// The reset is part of the compare-and-swap, so no concurrent caller can be handed a value
+		// that the reset then hands out again.
+		reset := next < prev || next >= math.MaxUint64
+		if reset {
+			first, next = 0, uint64(count)
+		}
+
+		if counter.CompareAndSwap(prev, next) {
+			if reset {
+				log.Println("GUID has reached the maximum value!")
+			}
+			return first
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package idgen
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReserveCounts(t *testing.T) {
+	var counter atomic.Uint64
+	if first := reserveCounts(&counter, 10); first != 0 || counter.Load() != 10 {
+		t.Errorf("got first=%d counter=%d, want 0 and 10", first, counter.Load())
+	}
+	if first := reserveCounts(&counter, 1); first != 10 {
+		t.Errorf("got first=%d, want 10", first)
+	}
+
+	// A range that would reach the maximum value is taken from zero instead.
+	counter.Store(math.MaxUint64 - 5)
+	if first := reserveCounts(&counter, 5); first != 0 || counter.Load() != 5 {
+		t.Errorf("got first=%d counter=%d, want 0 and 5", first, counter.Load())
+	}
+
+	// Concurrent callers racing the reset are never handed the same value.
+	counter.Store(math.MaxUint64 - 1000)
+	var (
+		mu   sync.Mutex
+		seen = make(map[uint64]bool)
+		wg   sync.WaitGroup
+	)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				first := reserveCounts(&counter, 3)
+
+				mu.Lock()
+				for v := first; v < first+3; v++ {
+					if seen[v] {
+						t.Errorf("value %d handed out twice", v)
+					}
+					seen[v] = true
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,46 @@
+/*
+Package idgen generates globally unique IDs for the nodes of a Maelstrom cluster.
+
+Every scheme implements Generator:
+
+  - GUID combines the node's ID, a counter, the time and random bytes into a string.
+  - Timestamped builds Snowflake-style integers, ULIDs or version 7 UUIDs from a millisecond
+    timestamp, the node's index in the cluster and a per-millisecond sequence.
+  - Block hands out dense integers from blocks claimed with compare-and-swap on a shared key.
+  - TSO hands out the timestamps of a timestamp oracle.
+
+Decode parses the IDs of GUID and Timestamped back into their fields.
+*/
+package idgen
+
+import (
+	"context"
+	"fmt"
+)
+
+// Generator generates unique IDs. Implementations are safe for concurrent use.
+type Generator interface {
+	/*
+		Next returns new IDs.
+
+		Parameters:
+		  - ctx: bounds any requests to other services.
+		  - count: how many IDs to return, at least 1.
+
+		Returns:
+		  - The IDs, in the order they were generated: strings or int64s, depending on the scheme.
+		  - An error if no IDs could be generated. A *maelstrom.RPCError tells the client whether to
+		    retry.
+	*/
+	Next(ctx context.Context, count int) ([]any, error)
+}
+
+// NodeIndex returns the position of id in ids, which every node receives in the same order.
+func NodeIndex(id string, ids []string) (int, error) {
+	for i, other := range ids {
+		if other == id {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("node %s is not in the cluster %v", id, ids)
+}
@@ -0,0 +1,430 @@
+package idgen_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"maelstrom-unique-ids/idgen"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// memKV is an in-memory linearizable key-value store, answering like Maelstrom's lin-kv.
+type memKV struct {
+	mu   sync.Mutex
+	data map[string]int
+}
+
+func newMemKV() *memKV {
+	return &memKV{data: make(map[string]int)}
+}
+
+func (kv *memKV) Read(_ context.Context, key string) (any, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	v, ok := kv.data[key]
+	if !ok {
+		return nil, maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+	}
+	return v, nil
+}
+
+func (kv *memKV) CompareAndSwap(_ context.Context, key string, from, to any, createIfNotExists bool) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	// Values arrive as whatever integer type the client used; lin-kv would compare their JSON.
+	v, ok := kv.data[key]
+	if !ok && !createIfNotExists {
+		return maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+	}
+	if ok && fmt.Sprint(v) != fmt.Sprint(from) {
+		return maelstrom.NewRPCError(maelstrom.PreconditionFailed, fmt.Sprintf("expected %v, had %v", from, v))
+	}
+
+	n, err := fmt.Sscan(fmt.Sprint(to), &v)
+	if n != 1 {
+		return err
+	}
+	kv.data[key] = v
+	return nil
+}
+
+// memTSO is an in-memory timestamp oracle, answering like Maelstrom's lin-tso.
+type memTSO struct {
+	last atomic.Int64
+}
+
+func (o *memTSO) Timestamp(context.Context) (int64, error) {
+	return o.last.Add(1), nil
+}
+
+func TestNodeIndex(t *testing.T) {
+	ids := []string{"n0", "n1", "n2"}
+	if i, err := idgen.NodeIndex("n2", ids); err != nil || i != 2 {
+		t.Errorf("NodeIndex(n2) = %d, %v; want 2", i, err)
+	}
+	if _, err := idgen.NodeIndex("n3", ids); err == nil {
+		t.Error("NodeIndex(n3) succeeded")
+	}
+}
+
+/*
+TestGenerators_NoCollisions is a property test for every scheme: it runs thousands of generators
+at once, as the nodes of one simulated cluster sharing its services, has each generate IDs in
+batches of varying size, and checks that no ID is handed out twice.
+*/
+func TestGenerators_NoCollisions(t *testing.T) {
+	const perGenerator = 60 // Generated in batches of 1, 2, 3, 4, 5, 6, 1, 2, ...
+
+	schemes := map[string]struct {
+		generators int
+		new        func(t *testing.T, count int) []idgen.Generator
+	}{
+		"guid": {2000, func(t *testing.T, count int) []idgen.Generator {
+			gens := make([]idgen.Generator, count)
+			for i := range gens {
+				gens[i] = idgen.NewGUID(fmt.Sprintf("n%d", i))
+			}
+			return gens
+		}},
+		"block": {2000, func(t *testing.T, count int) []idgen.Generator {
+			kv := newMemKV()
+			gens := make([]idgen.Generator, count)
+			for i := range gens {
+				// Half the generators adapt their block size, the others keep it fixed.
+				cfg := idgen.BlockConfig{MinSize: 1 + i%16, MaxSize: 1 + i%16}
+				if i%2 == 0 {
+					cfg = idgen.BlockConfig{MinSize: 1, MaxSize: 64, Lifetime: time.Millisecond}
+				}
+				g, err := idgen.NewBlock(kv, "ids", cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				gens[i] = g
+			}
+			return gens
+		}},
+		"tso": {2000, func(t *testing.T, count int) []idgen.Generator {
+			oracle := &memTSO{}
+			gens := make([]idgen.Generator, count)
+			for i := range gens {
+				gens[i] = idgen.NewTSO(oracle)
+			}
+			return gens
+		}},
+	}
+	for _, format := range idgen.TimestampedFormats {
+		schemes[format] = struct {
+			generators int
+			new        func(t *testing.T, count int) []idgen.Generator
+		}{idgen.MaxNodes, func(t *testing.T, count int) []idgen.Generator {
+			gens := make([]idgen.Generator, count)
+			for i := range gens {
+				seq := idgen.NewSequencer(idgen.SequencerConfig{Borrow: i%2 == 0, MaxRegression: time.Second})
+				gens[i] = newTimestamped(t, format, i, seq)
+			}
+			return gens
+		}}
+	}
+
+	for name, scheme := range schemes {
+		t.Run(name, func(t *testing.T) {
+			gens := scheme.new(t, scheme.generators)
+
+			var (
+				mu   sync.Mutex
+				seen = make(map[any]bool, len(gens)*perGenerator)
+				wg   sync.WaitGroup
+			)
+			start := make(chan struct{})
+			for _, g := range gens {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+
+					var ids []any
+					for size := 1; len(ids) < perGenerator; size = size%6 + 1 {
+						batch, err := g.Next(context.Background(), size)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if len(batch) != size {
+							t.Errorf("asked for %d IDs, got %d", size, len(batch))
+							return
+						}
+						ids = append(ids, batch...)
+					}
+
+					mu.Lock()
+					defer mu.Unlock()
+					for _, id := range ids {
+						if seen[id] {
+							t.Errorf("duplicate ID %v", id)
+						}
+						seen[id] = true
+					}
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			if want := len(gens) * (perGenerator + 3); len(seen) < len(gens)*perGenerator || len(seen) > want {
+				t.Errorf("got %d distinct IDs from %d generators", len(seen), len(gens))
+			}
+		})
+	}
+}
+
+// TestBlock_Dense checks that generators which use up their blocks leave no gaps: together they
+// hand out exactly the IDs 1 to N.
+func TestBlock_Dense(t *testing.T) {
+	const generators, size, blocks = 50, 8, 20
+
+	kv := newMemKV()
+	ids := make(chan any, generators*size*blocks)
+	var wg sync.WaitGroup
+	for i := 0; i < generators; i++ {
+		g, err := idgen.NewBlock(kv, "ids", idgen.BlockConfig{MinSize: size, MaxSize: size})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < blocks; j++ {
+				batch, err := g.Next(context.Background(), size)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				for _, id := range batch {
+					ids <- id
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[int64]bool)
+	for id := range ids {
+		seen[id.(int64)] = true
+	}
+	for id := int64(1); id <= generators*size*blocks; id++ {
+		if !seen[id] {
+			t.Fatalf("ID %d was never handed out", id)
+		}
+	}
+	if len(seen) != generators*size*blocks {
+		t.Errorf("got %d distinct IDs, want %d", len(seen), generators*size*blocks)
+	}
+}
+
+func TestBlock_Errors(t *testing.T) {
+	for _, cfg := range []idgen.BlockConfig{{MinSize: 0, MaxSize: 10}, {MinSize: 10, MaxSize: 5}} {
+		if _, err := idgen.NewBlock(newMemKV(), "ids", cfg); err == nil {
+			t.Errorf("block sizes %+v accepted", cfg)
+		}
+	}
+
+	kv := newMemKV()
+	if err := kv.CompareAndSwap(context.Background(), "ids", nil, 5, true); err != nil {
+		t.Fatal(err)
+	}
+	g, err := idgen.NewBlock(kv, "ids", idgen.BlockConfig{MinSize: 10, MaxSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := g.Next(context.Background(), 1); err != nil || ids[0] != int64(6) {
+		t.Errorf("after 5 IDs were claimed: got %v, %v; want [6]", ids, err)
+	}
+
+	// A cancelled context stops a claim that keeps losing.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	g, _ = idgen.NewBlock(&losingKV{kv}, "ids", idgen.BlockConfig{MinSize: 10, MaxSize: 10})
+	if _, err := g.Next(ctx, 1); err == nil {
+		t.Error("a claim that always loses succeeded")
+	}
+}
+
+// TestBlock_Adapts checks that the block size grows while blocks run out quickly, and shrinks
+// again once they last.
+func TestBlock_Adapts(t *testing.T) {
+	kv := newMemKV()
+	clock := &fakeClock{t: time.Now()}
+	g, err := idgen.NewBlock(kv, "ids", idgen.BlockConfig{MinSize: 10, MaxSize: 1000, Lifetime: time.Second, Now: clock.now})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// claimed returns the size of the block claimed by taking the next ID, or 0 if none was.
+	claimed := func(step time.Duration) int {
+		clock.set(clock.now().Add(step))
+		before, _ := kv.Read(context.Background(), "ids")
+		if _, err := g.Next(context.Background(), 1); err != nil {
+			t.Fatal(err)
+		}
+		after, _ := kv.Read(context.Background(), "ids")
+		if before == nil {
+			return after.(int)
+		}
+		return after.(int) - before.(int)
+	}
+
+	// An ID every 100µs: each block is twice the last, up to MaxSize.
+	var sizes []int
+	for len(sizes) < 9 {
+		if size := claimed(100 * time.Microsecond); size != 0 {
+			sizes = append(sizes, size)
+		}
+	}
+	if want := []int{10, 20, 40, 80, 160, 320, 640, 1000, 1000}; !slices.Equal(sizes, want) {
+		t.Errorf("while busy: claimed blocks of %v, want %v", sizes, want)
+	}
+
+	// An ID a second: each block is half the last, down to MinSize.
+	sizes = nil
+	for len(sizes) < 9 {
+		if size := claimed(time.Second); size != 0 {
+			sizes = append(sizes, size)
+		}
+	}
+	if want := []int{500, 250, 125, 62, 31, 15, 10, 10, 10}; !slices.Equal(sizes, want) {
+		t.Errorf("while idle: claimed blocks of %v, want %v", sizes, want)
+	}
+
+	// A batch larger than the block size claims what it needs at once.
+	if _, err := g.Next(context.Background(), 2000); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestBlock_Partition cuts a generator off from the store: it hands out the rest of its block,
+// then refuses, then resumes without repeating an ID once the store is reachable again.
+func TestBlock_Partition(t *testing.T) {
+	kv := &partitionedKV{memKV: newMemKV()}
+	g, err := idgen.NewBlock(kv, "ids", idgen.BlockConfig{MinSize: 10, MaxSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[any]bool)
+	next := func(count int) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		ids, err := g.Next(ctx, count)
+		for _, id := range ids {
+			if seen[id] {
+				t.Fatalf("duplicate ID %v", id)
+			}
+			seen[id] = true
+		}
+		return err
+	}
+
+	if err := next(4); err != nil {
+		t.Fatal(err)
+	}
+	kv.cut.Store(true)
+	if err := next(6); err != nil {
+		t.Errorf("the rest of the block: %v", err)
+	}
+
+	var rpcErr *maelstrom.RPCError
+	if err := next(1); !errors.As(err, &rpcErr) || rpcErr.Code != maelstrom.TemporarilyUnavailable {
+		t.Errorf("block used up during a partition: got %v, want a temporarily-unavailable error", err)
+	}
+
+	kv.cut.Store(false)
+	if err := next(5); err != nil {
+		t.Errorf("after the partition healed: %v", err)
+	}
+	if !seen[int64(11)] || len(seen) != 15 {
+		t.Errorf("handed out %v, want 1 to 15", seen)
+	}
+}
+
+// partitionedKV is a store that, while cut is set, is unreachable: requests time out.
+type partitionedKV struct {
+	*memKV
+	cut atomic.Bool
+}
+
+func (kv *partitionedKV) Read(ctx context.Context, key string) (any, error) {
+	if kv.cut.Load() {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return kv.memKV.Read(ctx, key)
+}
+
+func (kv *partitionedKV) CompareAndSwap(ctx context.Context, key string, from, to any, createIfNotExists bool) error {
+	if kv.cut.Load() {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return kv.memKV.CompareAndSwap(ctx, key, from, to, createIfNotExists)
+}
+
+// losingKV is a store on which every compare-and-swap loses to another node.
+type losingKV struct {
+	*memKV
+}
+
+func (kv *losingKV) CompareAndSwap(context.Context, string, any, any, bool) error {
+	return maelstrom.NewRPCError(maelstrom.PreconditionFailed, "lost")
+}
+
+// BenchmarkGenerators compares the throughput of the schemes, generating from all CPUs at once
+// with one generator each, as if every CPU were a node.
+func BenchmarkGenerators(b *testing.B) {
+	schemes := map[string]func(node int) idgen.Generator{
+		"guid": func(node int) idgen.Generator { return idgen.NewGUID(fmt.Sprintf("n%d", node)) },
+	}
+	kv, oracle := newMemKV(), &memTSO{}
+	schemes["block"] = func(int) idgen.Generator {
+		g, _ := idgen.NewBlock(kv, "ids", idgen.BlockConfig{MinSize: 1000, MaxSize: 1000})
+		return g
+	}
+	schemes["tso"] = func(int) idgen.Generator { return idgen.NewTSO(oracle) }
+	for _, format := range idgen.TimestampedFormats {
+		for _, borrow := range []bool{false, true} {
+			name := format
+			if borrow {
+				name += "/borrow"
+			}
+			schemes[name] = func(node int) idgen.Generator {
+				seq := idgen.NewSequencer(idgen.SequencerConfig{Borrow: borrow, MaxRegression: time.Second})
+				g, _ := idgen.NewTimestamped(format, node, seq)
+				return g
+			}
+		}
+	}
+
+	for name, newGenerator := range schemes {
+		b.Run(name, func(b *testing.B) {
+			var nodes atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				g := newGenerator(int(nodes.Add(1)-1) % idgen.MaxNodes)
+				for pb.Next() {
+					if _, err := g.Next(context.Background(), 1); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
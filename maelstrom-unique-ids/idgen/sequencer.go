@@ -0,0 +1,130 @@
+package idgen
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// MaxSequence is the largest sequence number; every timestamped format has 12 bits for it.
+const MaxSequence = 1<<12 - 1
+
+// ErrClockRegression is returned by a Sequencer when the clock has moved back too far.
+var ErrClockRegression = errors.New("clock regression")
+
+/*
+Sequencer is a hybrid logical clock that issues the (millisecond, sequence) pairs that the
+timestamped ID formats are built from. The millisecond is the physical part, the highest Unix
+time in milliseconds the clock has shown, and the sequence the logical part, counting the pairs
+issued in that millisecond. Each pair is greater than the last, even if the clock moves back.
+
+When a millisecond's 4096 sequence numbers run out, Next either waits for the clock to reach the
+next millisecond or, if Borrow is set, moves on to it at once; under sustained load, borrowing
+lets the timestamps run ahead of the clock until the load drops.
+
+If the clock moves back, by an NTP step or a VM migration say, the sequencer logs it and keeps
+issuing pairs from the latest millisecond it has seen until the clock catches up. If it has
+moved back by more than MaxRegression, it refuses to issue pairs at all, since a clock that
+wrong may also be wrong elsewhere. It is safe for concurrent use.
+*/
+type Sequencer struct {
+	cfg SequencerConfig
+
+	mu         sync.Mutex // mu guards access to the fields below.
+	last       int64      // Unix milliseconds of the last pair.
+	seq        int64      // Sequence number of the last pair.
+	wall       int64      // The latest Unix milliseconds the clock has shown.
+	regressing bool       // Whether the clock is behind wall.
+}
+
+// SequencerConfig configures a Sequencer.
+type SequencerConfig struct {
+	Borrow        bool             // Whether to borrow the next millisecond rather than wait for it.
+	MaxRegression time.Duration    // How far the clock may move back before pairs are refused.
+	Now           func() time.Time // The clock; nil for time.Now.
+}
+
+// NewSequencer initializes and returns a pointer to a new Sequencer.
+func NewSequencer(cfg SequencerConfig) *Sequencer {
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Sequencer{cfg: cfg, last: -1, wall: -1}
+}
+
+// Next returns a new millisecond and sequence number, or an error wrapping ErrClockRegression.
+func (s *Sequencer) Next() (ms, seq int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.advance()
+}
+
+// NextN returns count new pairs of millisecond and sequence number, in increasing order, taking
+// the lock only once, or an error wrapping ErrClockRegression.
+func (s *Sequencer) NextN(count int) (ms, seq []int64, err error) {
+	ms, seq = make([]int64, count), make([]int64, count)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range ms {
+		if ms[i], seq[i], err = s.advance(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return ms, seq, nil
+}
+
+// advance issues the next pair. The caller must hold s.mu.
+func (s *Sequencer) advance() (ms, seq int64, err error) {
+	ms, err = s.read()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	switch {
+	case ms > s.last:
+		s.last, s.seq = ms, 0
+	case s.seq < MaxSequence:
+		s.seq++
+	case s.cfg.Borrow:
+		s.last, s.seq = s.last+1, 0
+	default:
+		for ms <= s.last {
+			time.Sleep(time.UnixMilli(s.last + 1).Sub(s.cfg.Now()))
+			if ms, err = s.read(); err != nil {
+				return 0, 0, err
+			}
+		}
+		s.last, s.seq = ms, 0
+	}
+
+	return s.last, s.seq, nil
+}
+
+// read returns the clock's Unix milliseconds, noting and logging any regression, or an error if
+// the clock is more than MaxRegression behind the latest time it has shown. The caller must hold
+// s.mu.
+func (s *Sequencer) read() (int64, error) {
+	ms := s.cfg.Now().UnixMilli()
+	if ms >= s.wall {
+		if s.regressing {
+			log.Printf("Clock has caught up after moving back")
+			s.regressing = false
+		}
+		s.wall = ms
+		return ms, nil
+	}
+
+	behind := time.Duration(s.wall-ms) * time.Millisecond
+	if !s.regressing {
+		log.Printf("Clock moved back %v; issuing IDs from the latest time seen until it catches up", behind)
+		s.regressing = true
+	}
+	if behind > s.cfg.MaxRegression {
+		return 0, fmt.Errorf("%w: clock is %v behind the latest time seen, more than the %v allowed",
+			ErrClockRegression, behind, s.cfg.MaxRegression)
+	}
+	return ms, nil
+}
@@ -0,0 +1,110 @@
+package idgen_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"maelstrom-unique-ids/idgen"
+)
+
+// fakeClock is a clock for tests that advances by step every time it is read.
+type fakeClock struct {
+	mu   sync.Mutex
+	t    time.Time
+	step time.Duration
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(c.step)
+	return c.t
+}
+
+// set moves the clock to t.
+func (c *fakeClock) set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = t
+}
+
+// TestSequencer_Overflow issues more pairs than one millisecond's sequence numbers allow.
+func TestSequencer_Overflow(t *testing.T) {
+	t.Run("wait", func(t *testing.T) {
+		clock := &fakeClock{t: idgen.SnowflakeEpoch, step: 10 * time.Microsecond}
+		s := idgen.NewSequencer(idgen.SequencerConfig{MaxRegression: time.Second, Now: clock.now})
+
+		for i := 0; i < 3*idgen.MaxSequence; i++ {
+			if ms, _, err := s.Next(); err != nil || ms > clock.now().UnixMilli() {
+				t.Fatalf("pair stamped %dms at %dms, err %v", ms, clock.now().UnixMilli(), err)
+			}
+		}
+	})
+
+	t.Run("borrow", func(t *testing.T) {
+		s := idgen.NewSequencer(idgen.SequencerConfig{
+			Borrow:        true,
+			MaxRegression: time.Second,
+			Now:           func() time.Time { return idgen.SnowflakeEpoch }, // Frozen.
+		})
+
+		var lastMS, lastSeq int64 = -1, 0
+		for i := 0; i < 3*(idgen.MaxSequence+1); i++ {
+			ms, seq, err := s.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ms < lastMS || ms == lastMS && seq <= lastSeq {
+				t.Fatalf("pair (%d, %d) after (%d, %d)", ms, seq, lastMS, lastSeq)
+			}
+			lastMS, lastSeq = ms, seq
+		}
+		if want := idgen.SnowflakeEpoch.UnixMilli() + 2; lastMS != want || lastSeq != idgen.MaxSequence {
+			t.Errorf("last pair is (%d, %d), want (%d, %d)", lastMS, lastSeq, want, idgen.MaxSequence)
+		}
+	})
+}
+
+// TestSequencer_ClockRegression moves the clock back and checks that pairs keep increasing while
+// the regression is within bounds, and are refused beyond it.
+func TestSequencer_ClockRegression(t *testing.T) {
+	clock := &fakeClock{t: idgen.SnowflakeEpoch}
+	s := idgen.NewSequencer(idgen.SequencerConfig{MaxRegression: 100 * time.Millisecond, Now: clock.now})
+
+	var lastMS, lastSeq int64 = -1, 0
+	next := func() error {
+		ms, seq, err := s.Next()
+		if err != nil {
+			return err
+		}
+		if ms < lastMS || ms == lastMS && seq <= lastSeq {
+			t.Fatalf("pair (%d, %d) after (%d, %d)", ms, seq, lastMS, lastSeq)
+		}
+		lastMS, lastSeq = ms, seq
+		return nil
+	}
+
+	for _, step := range []struct {
+		at      time.Duration
+		refused bool
+	}{
+		{time.Second, false},
+		{time.Second - 50*time.Millisecond, false}, // Within bounds: keeps using the latest millisecond.
+		{time.Second - 200*time.Millisecond, true}, // Beyond them.
+		{time.Second - 90*time.Millisecond, false}, // Back within bounds.
+		{time.Second + time.Millisecond, false},    // Caught up.
+	} {
+		clock.set(idgen.SnowflakeEpoch.Add(step.at))
+		for i := 0; i < 10; i++ {
+			err := next()
+			if step.refused != errors.Is(err, idgen.ErrClockRegression) {
+				t.Fatalf("clock at %v: got %v, want refused=%v", step.at, err, step.refused)
+			}
+		}
+	}
+	if want := idgen.SnowflakeEpoch.Add(time.Second + time.Millisecond).UnixMilli(); lastMS != want {
+		t.Errorf("last pair at %dms, want %dms", lastMS, want)
+	}
+}
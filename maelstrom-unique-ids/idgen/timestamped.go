@@ -0,0 +1,149 @@
+package idgen
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const (
+	nodeBits = 10
+
+	// MaxNodes is the most nodes a cluster may have for Timestamped IDs to stay unique.
+	MaxNodes = 1 << nodeBits
+)
+
+// SnowflakeEpoch is the zero of snowflake timestamps, so that 41 bits of milliseconds last until 2094.
+var SnowflakeEpoch = time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// crockford is the Crockford base32 alphabet that ULIDs are written in.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// TimestampedFormats lists the formats of Timestamped IDs.
+var TimestampedFormats = []string{"snowflake", "ulid", "uuidv7"}
+
+/*
+Timestamped generates IDs from a node's index in the cluster and the (millisecond, sequence)
+pairs of a Sequencer, in one of TimestampedFormats; see EncodeSnowflake, EncodeULID and
+EncodeUUIDv7. IDs from different nodes never collide, and a node's IDs sort in the order they
+were generated.
+*/
+type Timestamped struct {
+	format string
+	node   int64
+	seq    *Sequencer
+}
+
+/*
+NewTimestamped initializes and returns a pointer to a new Timestamped generator.
+
+Parameters:
+  - format: one of TimestampedFormats.
+  - node: the node's index in the cluster; see NodeIndex.
+  - seq: issues the timestamps and sequence numbers. Generators of different formats on one
+    node may share it.
+
+Returns:
+  - The generator.
+  - An error if the format is unknown or node doesn't fit in 10 bits.
+*/
+func NewTimestamped(format string, node int, seq *Sequencer) (*Timestamped, error) {
+	switch format {
+	case "snowflake", "ulid", "uuidv7":
+	default:
+		return nil, fmt.Errorf("unknown format %q, want one of %v", format, TimestampedFormats)
+	}
+	if node < 0 || node >= MaxNodes {
+		return nil, fmt.Errorf("%s IDs support at most %d nodes, got node index %d", format, MaxNodes, node)
+	}
+	return &Timestamped{format: format, node: int64(node), seq: seq}, nil
+}
+
+// Next implements Generator. The batch is reserved in a single acquisition of the Sequencer's
+// lock. It fails with TemporarilyUnavailable if the clock has moved back too far.
+func (g *Timestamped) Next(_ context.Context, count int) ([]any, error) {
+	ms, seq, err := g.seq.NextN(count)
+	if err != nil {
+		return nil, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, err.Error())
+	}
+
+	ids := make([]any, count)
+	for i := range ids {
+		switch g.format {
+		case "snowflake":
+			ids[i] = EncodeSnowflake(ms[i], g.node, seq[i])
+		case "ulid":
+			ids[i] = EncodeULID(ms[i], g.node, seq[i], binary.BigEndian.Uint64(generateRandomBytes()))
+		default:
+			ids[i] = EncodeUUIDv7(ms[i], g.node, seq[i], binary.BigEndian.Uint64(generateRandomBytes()))
+		}
+	}
+	return ids, nil
+}
+
+/*
+EncodeSnowflake returns a Snowflake-style 64-bit ID. From the most significant bit, it is:
+
+  - 1 unused bit, so that IDs are positive int64s.
+  - 41 bits of milliseconds since SnowflakeEpoch.
+  - 10 bits of node index.
+  - 12 bits of sequence.
+
+Parameters:
+  - ms, seq: a pair issued by the node's Sequencer.
+  - node: the node's index in the cluster, below MaxNodes.
+*/
+func EncodeSnowflake(ms, node, seq int64) int64 {
+	return (ms-SnowflakeEpoch.UnixMilli())<<(nodeBits+12) | node<<12 | seq
+}
+
+/*
+EncodeULID returns a ULID: a 128-bit value written as 26 Crockford base32 characters, whose first
+48 bits are a Unix timestamp in milliseconds and whose other 80 bits are free. This one fills
+them with:
+
+  - 10 bits of node index.
+  - 12 bits of sequence.
+  - 58 random bits.
+
+Parameters:
+  - ms, seq: a pair issued by the node's Sequencer.
+  - node: the node's index in the cluster, below MaxNodes.
+  - random: random bits; only the lowest 58 are used.
+*/
+func EncodeULID(ms, node, seq int64, random uint64) string {
+	hi := uint64(ms)<<16 | uint64(node)<<6 | uint64(seq)>>6
+	lo := uint64(seq)<<58 | random&(1<<58-1)
+
+	// 26 characters of 5 bits hold 130 bits, so the first character only holds the top 3.
+	var b [26]byte
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(b[:])
+}
+
+/*
+EncodeUUIDv7 returns an RFC 9562 version 7 UUID. Its 48-bit unix_ts_ms field holds the
+timestamp, its 12-bit rand_a field the sequence, used as the fixed-length counter the RFC allows,
+and its 62-bit rand_b field:
+
+  - 10 bits of node index.
+  - 52 random bits.
+
+Parameters:
+  - ms, seq: a pair issued by the node's Sequencer.
+  - node: the node's index in the cluster, below MaxNodes.
+  - random: random bits; only the lowest 52 are used.
+*/
+func EncodeUUIDv7(ms, node, seq int64, random uint64) string {
+	hi := uint64(ms)<<16 | 0x7<<12 | uint64(seq)
+	lo := 0b10<<62 | uint64(node)<<52 | random&(1<<52-1)
+
+	return fmt.Sprintf("%08x-%04x-%04x-%04x-%012x", hi>>32, hi>>16&0xffff, hi&0xffff, lo>>48, lo&(1<<48-1))
+}
@@ -0,0 +1,150 @@
+package idgen_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"maelstrom-unique-ids/idgen"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// splitSnowflake returns the Unix milliseconds, node index and sequence of a snowflake ID.
+func splitSnowflake(id int64) (ms, node, seq int64) {
+	return id>>22 + idgen.SnowflakeEpoch.UnixMilli(), id >> 12 & (idgen.MaxNodes - 1), id & idgen.MaxSequence
+}
+
+// less reports whether the ID a sorts before b: as numbers for snowflake IDs and as strings otherwise.
+func less(a, b any) bool {
+	if a, ok := a.(int64); ok {
+		return a < b.(int64)
+	}
+	return a.(string) < b.(string)
+}
+
+// newTimestamped returns a Timestamped generator, failing the test on error.
+func newTimestamped(t testing.TB, format string, node int, seq *idgen.Sequencer) *idgen.Timestamped {
+	t.Helper()
+
+	g, err := idgen.NewTimestamped(format, node, seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestEncodeSnowflake_Layout(t *testing.T) {
+	ms := idgen.SnowflakeEpoch.UnixMilli() + 1234
+	seq := idgen.NewSequencer(idgen.SequencerConfig{Now: func() time.Time { return time.UnixMilli(ms) }})
+	g := newTimestamped(t, "snowflake", 5, seq)
+
+	ids, err := g.Next(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for want, id := range ids {
+		gotMS, node, seq := splitSnowflake(id.(int64))
+		if gotMS != ms || node != 5 || seq != int64(want) {
+			t.Errorf("got ms=%d node=%d seq=%d, want ms=%d node=5 seq=%d", gotMS, node, seq, ms, want)
+		}
+	}
+}
+
+func TestEncodeULIDAndUUIDv7_Layout(t *testing.T) {
+	ms := time.Date(2025, time.June, 1, 12, 0, 0, 0, time.UTC).UnixMilli()
+
+	ulid := idgen.EncodeULID(ms, 5, 3, 0)
+	if want := "01JWNNSVG0" + "05" + "00R" + "00000000000"; ulid != want { // Timestamp, node, sequence, random.
+		t.Errorf("EncodeULID = %s, want %s", ulid, want)
+	}
+	if !regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`).MatchString(idgen.EncodeULID(ms, idgen.MaxNodes-1, idgen.MaxSequence, ^uint64(0))) {
+		t.Errorf("EncodeULID with every field full isn't a valid ULID")
+	}
+
+	uuid := idgen.EncodeUUIDv7(ms, 5, 3, 0)
+	if want := fmt.Sprintf("%08x-%04x-7003-8050-000000000000", ms>>16, ms&0xffff); uuid != want {
+		t.Errorf("EncodeUUIDv7 = %s, want %s", uuid, want)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(idgen.EncodeUUIDv7(ms, idgen.MaxNodes-1, idgen.MaxSequence, ^uint64(0))) {
+		t.Errorf("EncodeUUIDv7 with every field full isn't a valid version 7 UUID")
+	}
+}
+
+// TestTimestamped_Order generates IDs in every timestamped format on several nodes at once, with
+// several goroutines per node, and checks that no ID repeats and that each goroutine's IDs sort
+// in the order they were generated.
+func TestTimestamped_Order(t *testing.T) {
+	const nodes, workers, perWorker = 4, 4, 2000
+
+	for _, format := range idgen.TimestampedFormats {
+		t.Run(format, func(t *testing.T) {
+			var (
+				mu   sync.Mutex
+				seen = make(map[any]bool, nodes*workers*perWorker)
+				wg   sync.WaitGroup
+			)
+			for node := 0; node < nodes; node++ {
+				seq := idgen.NewSequencer(idgen.SequencerConfig{Borrow: node%2 == 0, MaxRegression: time.Second})
+				g := newTimestamped(t, format, node, seq)
+
+				for w := 0; w < workers; w++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+
+						ids := make([]any, perWorker)
+						for i := range ids {
+							batch, err := g.Next(context.Background(), 1)
+							if err != nil {
+								t.Error(err)
+								return
+							}
+							if i > 0 && !less(ids[i-1], batch[0]) {
+								t.Errorf("node %d: ID %v after %v", node, batch[0], ids[i-1])
+								return
+							}
+							ids[i] = batch[0]
+						}
+
+						mu.Lock()
+						defer mu.Unlock()
+						for _, id := range ids {
+							if seen[id] {
+								t.Errorf("duplicate ID %v", id)
+							}
+							seen[id] = true
+						}
+					}()
+				}
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestTimestamped_Errors(t *testing.T) {
+	seq := idgen.NewSequencer(idgen.SequencerConfig{MaxRegression: time.Second})
+	if _, err := idgen.NewTimestamped("uuidv4", 0, seq); err == nil {
+		t.Error("unknown format accepted")
+	}
+	if _, err := idgen.NewTimestamped("ulid", idgen.MaxNodes, seq); err == nil {
+		t.Errorf("node index %d accepted", idgen.MaxNodes)
+	}
+
+	clock := &fakeClock{t: time.Now()}
+	g := newTimestamped(t, "ulid", 0, idgen.NewSequencer(idgen.SequencerConfig{MaxRegression: time.Second, Now: clock.now}))
+	if _, err := g.Next(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.set(clock.now().Add(-time.Minute))
+	var rpcErr *maelstrom.RPCError
+	if _, err := g.Next(context.Background(), 1); !errors.As(err, &rpcErr) || rpcErr.Code != maelstrom.TemporarilyUnavailable {
+		t.Errorf("clock moved back a minute: got %v, want a temporarily-unavailable error", err)
+	}
+}
@@ -0,0 +1,63 @@
+package idgen
+
+import (
+	"context"
+	"encoding/json"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// TimestampOracle issues timestamps, each greater than every one issued before it.
+type TimestampOracle interface {
+	Timestamp(ctx context.Context) (int64, error)
+}
+
+// TSO hands out the timestamps of a TimestampOracle as integer IDs. They are unique however many
+// nodes share the oracle, but each costs a request to it.
+type TSO struct {
+	oracle TimestampOracle
+}
+
+// NewTSO initializes and returns a pointer to a new TSO generator drawing from oracle.
+func NewTSO(oracle TimestampOracle) *TSO {
+	return &TSO{oracle: oracle}
+}
+
+// Next implements Generator, requesting one timestamp per ID. It fails with the oracle's error.
+func (g *TSO) Next(ctx context.Context, count int) ([]any, error) {
+	ids := make([]any, count)
+	for i := range ids {
+		ts, err := g.oracle.Timestamp(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = ts
+	}
+	return ids, nil
+}
+
+// LinTSO is a TimestampOracle backed by Maelstrom's lin-tso service.
+type LinTSO struct {
+	n *maelstrom.Node
+}
+
+// NewLinTSO returns a client for the lin-tso service, sending its requests from n.
+func NewLinTSO(n *maelstrom.Node) *LinTSO {
+	return &LinTSO{n: n}
+}
+
+// Timestamp implements TimestampOracle.
+func (t *LinTSO) Timestamp(ctx context.Context) (int64, error) {
+	msg, err := t.n.SyncRPC(ctx, "lin-tso", map[string]any{"type": "ts"})
+	if err != nil {
+		return 0, err
+	}
+
+	var body struct {
+		TS int64 `json:"ts"`
+	}
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return 0, err
+	}
+	return body.TS, nil
+}
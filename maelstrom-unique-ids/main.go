@@ -1,101 +1,236 @@
 package main
 
 import (
-    "encoding/json"
-    "strconv"
-    "log"
-    "math"
-    "sync/atomic"
-    "time"
-    "crypto/rand"
-    "fmt"
-    maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"maelstrom-unique-ids/idgen"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
 )
 
-// generateRandomBytes generates 8 random bytes to ensure uniqueness for the GUID.
-func generateRandomBytes() []byte {
-    randomBytes := make([]byte, 8)
-    _, err := rand.Read(randomBytes)
-
-    if err != nil {
-        log.Fatal("Error generating random bytes:", err)
-    }
-
-    return randomBytes
+// config holds the command-line options of the ID server.
+type config struct {
+	format        string        // The default ID format, one of formats.
+	borrow        bool          // Whether timestamped IDs borrow the next millisecond when the current one runs out.
+	maxRegression time.Duration // How far the clock may move back before timestamped IDs are refused.
+	maxBatch      int           // The largest count a generate_batch request may ask for.
+	blockMin      int           // The fewest dense IDs a node claims at once.
+	blockMax      int           // The most dense IDs a node claims at once.
+	blockLifetime time.Duration // How long a block of dense IDs should last.
+	timeout       time.Duration // How long a request may wait on lin-kv.
 }
 
-// createGUID creates a Globally Unique Identifier (GUID) based on the node's ID, 
-// an atomic counter, the current timestamp, and 64 random bits to ensure uniqueness.
-func createGUID(nodeID string, id uint64) string {
-    // Combine nodeID and ID to create a base unique key. 
-    // Example: For node n1, the ID could be n1_0; for node n2, it could be n2_0.
-    uniqueID := nodeID + "_" + strconv.FormatUint(id, 10)
+/*
+newIDNode creates a Maelstrom node that generates IDs.
 
-    // Append the current timestamp in nanoseconds to further ensure uniqueness during runtime.
-    currentTime := uint64(time.Now().UnixNano())
-    uniqueID += "_" + strconv.FormatUint(currentTime, 10)
+Parameters:
+  - cfg: the server's configuration.
 
-    // Generate random bytes and convert them to a hexadecimal string to prevent collisions, 
-    // even in cases where the timestamp might overflow.
-    randomBytes := generateRandomBytes()
-    randomString := fmt.Sprintf("%x", randomBytes)
+Returns:
+  - The node, ready to be run.
+  - An error if the format is unknown, or the largest batch or dense block sizes aren't positive.
+*/
+func newIDNode(cfg config) (*maelstrom.Node, error) {
+	if !slices.Contains(formats, cfg.format) {
+		return nil, fmt.Errorf("unknown format %q, want one of %v", cfg.format, formats)
+	}
+	if cfg.maxBatch < 1 {
+		return nil, fmt.Errorf("largest batch must be positive, got %d", cfg.maxBatch)
+	}
+	if cfg.blockMin < 1 || cfg.blockMax < cfg.blockMin {
+		return nil, fmt.Errorf("dense block sizes must satisfy 1 <= min <= max, got min %d and max %d", cfg.blockMin, cfg.blockMax)
+	}
+
+	// Initialize a new Maelstrom node for the program to run on.
+	n := maelstrom.NewNode()
+
+	// Most formats need the node's index in the cluster, so the generators are created on init.
+	var gens atomic.Pointer[generators]
+	n.Handle("init", func(msg maelstrom.Message) error {
+		g, err := newGenerators(n.ID(), n.NodeIDs(), maelstrom.NewLinKV(n), cfg)
+		if err != nil {
+			return err
+		}
+		gens.Store(&g)
+		return nil
+	})
+
+	// next returns count new IDs in format. Until an init has succeeded there are no generators,
+	// and requests are refused as temporarily unavailable.
+	next := func(ctx context.Context, format string, count int) ([]any, error) {
+		g := gens.Load()
+		if g == nil {
+			return nil, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "node is not initialized")
+		}
+		return g.next(ctx, format, count)
+	}
+
+	// Handle the "generate" message type by responding with a new unique ID.
+	n.Handle("generate", func(msg maelstrom.Message) error {
+		var body map[string]any
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		// The request may ask for a format other than the default.
+		format := cfg.format
+		if f, ok := body["format"].(string); ok {
+			format = f
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+		defer cancel()
+
+		ids, err := next(ctx, format, 1)
+		if err != nil {
+			return err
+		}
+
+		// Update the response type to indicate successful ID generation.
+		body["type"] = "generate_ok"
+		body["id"] = ids[0]
+
+		// Send the response back to the requester.
+		return replyExact(n, msg, body)
+	})
+
+	// Handle the "generate_batch" message type by responding with count new unique IDs.
+	n.Handle("generate_batch", func(msg maelstrom.Message) error {
+		var body generateBatchMessageBody
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+		if body.Count == nil || *body.Count < 1 || *body.Count > cfg.maxBatch {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest,
+				fmt.Sprintf("count must be between 1 and %d", cfg.maxBatch))
+		}
+
+		format := cfg.format
+		if body.Format != "" {
+			format = body.Format
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+		defer cancel()
+
+		ids, err := next(ctx, format, *body.Count)
+		if err != nil {
+			return err
+		}
+		return replyExact(n, msg, map[string]any{"type": "generate_batch_ok", "ids": ids})
+	})
+
+	// Handle the "inspect" message type by responding with the fields of the given ID.
+	n.Handle("inspect", func(msg maelstrom.Message) error {
+		// Decode numbers as json.Number, since a float64 can't hold every snowflake ID.
+		var body inspectMessageBody
+		dec := json.NewDecoder(bytes.NewReader(msg.Body))
+		dec.UseNumber()
+		if err := dec.Decode(&body); err != nil {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+
+		// Until init, node indexes couldn't be checked against the cluster.
+		if gens.Load() == nil {
+			return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "node is not initialized")
+		}
+
+		d, err := idgen.Decode(body.ID, n.NodeIDs(), time.Now())
+		if err != nil {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+		return n.Reply(msg, inspectOKMessageBody{Type: "inspect_ok", Decoded: d})
+	})
+
+	return n, nil
+}
 
-    uniqueID += "_" + randomString
+// replyExact replies to msg with body, like n.Reply. n.Reply decodes the body into a map before
+// sending it, which turns every number into a float64 and so corrupts snowflake IDs above 2^53.
+func replyExact(n *maelstrom.Node, msg maelstrom.Message, body map[string]any) error {
+	var req maelstrom.MessageBody
+	if err := json.Unmarshal(msg.Body, &req); err != nil {
+		return err
+	}
+	body["in_reply_to"] = req.MsgID
 
-    return uniqueID
+	return n.Send(msg.Src, body)
 }
 
-// incrementGUIDCount atomically increments the GUID counter and handles overflow.
-func incrementGUIDCount(GUID *atomic.Uint64) uint64 {
-    // Atomically increment the counter and return the previous value.
-    previousValue := GUID.Add(1) - 1
+// generateBatchMessageBody is the body of a "generate_batch" request.
+type generateBatchMessageBody struct {
+	Count  *int   `json:"count"`
+	Format string `json:"format,omitempty"`
+}
 
-    // If the GUID counter reaches the maximum value, reset it to zero to prevent overflow.
-    if previousValue == math.MaxUint64-1 {
-        log.Println("GUID has reached the maximum value!")
-        GUID.Store(0)
-    }
+// inspectMessageBody is the body of an "inspect" request.
+type inspectMessageBody struct {
+	ID any `json:"id"`
+}
 
-    return previousValue
+// inspectOKMessageBody is the body of an "inspect_ok" reply.
+type inspectOKMessageBody struct {
+	Type string `json:"type"`
+	idgen.Decoded
 }
 
 /*
-    This program generates Globally Unique Identifiers (GUIDs) by combining the node's ID, 
-    an atomic counter, the current timestamp in nanoseconds, and 64 randomly generated bits. 
-    These components together ensure the creation of globally unique IDs for each node, even across distributed systems.
+This program generates globally unique IDs, using the generators of package idgen.
+
+With -format guid (the default) it generates Globally Unique Identifiers (GUIDs) by combining
+the node's ID, an atomic counter, the current timestamp in nanoseconds, and 64 randomly
+generated bits. These components together ensure the creation of globally unique IDs for each
+node, even across distributed systems.
+With -format snowflake an ID is a 64-bit integer made of a millisecond timestamp, the node's
+index in the cluster and a per-millisecond sequence. -format ulid and -format uuidv7 return
+ULIDs and version 7 UUIDs, which sort by time and carry the same fields plus random bits.
+With -format dense IDs are small integers from 1, handed out from blocks that each node claims
+on lin-kv; see idgen.Block. A node that can't reach lin-kv within -timeout once its block runs
+out refuses to issue them.
+Timestamps never go backwards, and IDs are refused while the clock is more than
+-max-clock-regression behind the latest time it showed; see idgen.Timestamped.
+A generate request may override the format with its "format" field. A "generate_batch" request
+returns up to -max-batch IDs at once, and an "inspect" request decodes any of these IDs; see
+idgen.Decode.
+
+Run as "maelstrom-unique-ids inspect [-nodes n0,n1,...] ID..." it decodes IDs and exits; see
+runInspect.
 */
 func main() {
-    // Initialize a new Maelstrom node for the program to run on.
-    n := maelstrom.NewNode()
-
-    // Create an atomic counter to track unique GUIDs for this particular node.
-    var GUID atomic.Uint64
-
-    // Handle the "generate" message type by responding with a new unique GUID.
-    n.Handle("generate", func(msg maelstrom.Message) error {
-        var body map[string]any
-        if err := json.Unmarshal(msg.Body, &body); err != nil {
-            return err
-        }
-    
-        // Update the response type to indicate successful GUID generation.
-        body["type"] = "generate_ok"
-
-        // Atomically increment the GUID counter and get the previous value.
-        id := incrementGUIDCount(&GUID)
-
-        // Create a unique GUID by combining the node ID, the atomic counter, the timestamp, and random bytes.
-        uniqueID := createGUID(n.ID(), id)
-
-        // Add the generated uniqueID to the response body.
-        body["id"] = uniqueID
-
-        // Send the response back to the requester.
-        return n.Reply(msg, body)
-    })    
-
-    // Start the Maelstrom node, which listens for incoming messages.
-    if err := n.Run(); err != nil {
-        log.Fatal(err)
-    }
+	if len(os.Args) > 1 && os.Args[1] == "inspect" {
+		if err := runInspect(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var cfg config
+	flag.StringVar(&cfg.format, "format", "guid", "default ID format: guid (node, counter, time and random bytes), dense (integers from 1, claimed from lin-kv), snowflake (64-bit integer), ulid or uuidv7")
+	flag.BoolVar(&cfg.borrow, "borrow", false, "timestamped formats: when 4096 IDs are generated in one millisecond, borrow the next one instead of waiting for it")
+	flag.DurationVar(&cfg.maxRegression, "max-clock-regression", time.Second, "timestamped formats: how far the clock may move back before IDs are refused")
+	flag.IntVar(&cfg.maxBatch, "max-batch", 10000, "the most IDs a generate_batch request may ask for")
+	flag.IntVar(&cfg.blockMin, "dense-block-min", 10, "dense format: the fewest IDs a node claims at once")
+	flag.IntVar(&cfg.blockMax, "dense-block-max", 1000, "dense format: the most IDs a node claims at once")
+	flag.DurationVar(&cfg.blockLifetime, "dense-block-lifetime", time.Second, "dense format: how long a block should last; the block size adapts to the request rate to match")
+	flag.DurationVar(&cfg.timeout, "timeout", time.Second, "how long a request may wait on lin-kv before it is refused")
+	flag.Parse()
+
+	n, err := newIDNode(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Start the Maelstrom node, which listens for incoming messages.
+	if err := n.Run(); err != nil {
+		log.Fatal(err)
+	}
 }
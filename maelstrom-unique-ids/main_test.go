@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"maelstrom-unique-ids/idgen"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestMain(m *testing.M) {
+	// maelstrom.Node logs every message it sends and receives.
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// testNode is an ID server run over pipes, which a test sends requests to one at a time.
+type testNode struct {
+	in      *io.PipeWriter
+	replies chan map[string]any
+	msgID   int
+}
+
+// testConfig returns the server's default configuration with format as the default format.
+func testConfig(format string) config {
+	return config{
+		format:        format,
+		maxRegression: time.Second,
+		maxBatch:      10000,
+		blockMin:      10,
+		blockMax:      1000,
+		blockLifetime: time.Second,
+		timeout:       time.Second,
+	}
+}
+
+// linKV stands in for Maelstrom's lin-kv service, answering the nodes that share it.
+type linKV struct {
+	mu   sync.Mutex
+	data map[string]string // Values as JSON.
+	cut  atomic.Bool       // Whether requests are dropped, as in a partition.
+}
+
+func newLinKV() *linKV {
+	return &linKV{data: make(map[string]string)}
+}
+
+// serve answers the read or cas request body.
+func (kv *linKV) serve(body map[string]any) map[string]any {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	key := body["key"].(string)
+	v, ok := kv.data[key]
+	switch {
+	case body["type"] == "read" && ok:
+		return map[string]any{"type": "read_ok", "value": json.Number(v)}
+	case body["type"] == "cas" && (ok || body["create_if_not_exists"] == true):
+		if ok && fmt.Sprint(body["from"]) != v {
+			return map[string]any{"type": "error", "code": maelstrom.PreconditionFailed, "text": "from doesn't match"}
+		}
+		kv.data[key] = fmt.Sprint(body["to"])
+		return map[string]any{"type": "cas_ok"}
+	default:
+		return map[string]any{"type": "error", "code": maelstrom.KeyDoesNotExist, "text": "key does not exist"}
+	}
+}
+
+// startNode runs a node with cfg as node id of a cluster of ids, and initializes it. Its lin-kv
+// requests are answered by kv.
+func startNode(t *testing.T, cfg config, id string, ids []string, kv *linKV) *testNode {
+	t.Helper()
+
+	tn := runNode(t, cfg, kv)
+	if reply := tn.call(t, map[string]any{"type": "init", "node_id": id, "node_ids": ids}); reply["type"] != "init_ok" {
+		t.Fatalf("init: got %v", reply)
+	}
+	return tn
+}
+
+// runNode runs a node with cfg, without initializing it. Its lin-kv requests are answered by kv.
+func runNode(t *testing.T, cfg config, kv *linKV) *testNode {
+	t.Helper()
+
+	n, err := newIDNode(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	n.Stdin, n.Stdout = inR, outW
+
+	tn := &testNode{in: inW, replies: make(chan map[string]any, 1)}
+	go n.Run()
+	go func() {
+		scanner := bufio.NewScanner(outR)
+		scanner.Buffer(nil, 16<<20)
+		for scanner.Scan() {
+			// Decode numbers as json.Number, since a float64 can't hold every snowflake ID.
+			var msg struct {
+				Src, Dest string
+				Body      map[string]any
+			}
+			dec := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+			dec.UseNumber()
+			if err := dec.Decode(&msg); err != nil {
+				panic(err)
+			}
+
+			if msg.Dest != "lin-kv" {
+				tn.replies <- msg.Body
+				continue
+			}
+			if kv.cut.Load() {
+				continue
+			}
+			reply := kv.serve(msg.Body)
+			reply["in_reply_to"] = msg.Body["msg_id"]
+			out, err := json.Marshal(map[string]any{"src": "lin-kv", "dest": msg.Src, "body": reply})
+			if err != nil {
+				panic(err)
+			}
+			inW.Write(append(out, '\n'))
+		}
+	}()
+	t.Cleanup(func() { inW.Close() })
+	return tn
+}
+
+// call sends body to the node from client c1 and returns the body of its reply.
+func (tn *testNode) call(t *testing.T, body map[string]any) map[string]any {
+	t.Helper()
+
+	tn.msgID++
+	body["msg_id"] = tn.msgID
+	msg, err := json.Marshal(map[string]any{"src": "c1", "dest": "n0", "body": body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tn.in.Write(append(msg, '\n')); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case reply := <-tn.replies:
+		return reply
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no reply to %v", body)
+		return nil
+	}
+}
+
+// TestGenerate_Format checks that a generate request may override the node's default format.
+func TestGenerate_Format(t *testing.T) {
+	tn := startNode(t, testConfig("snowflake"), "n1", []string{"n0", "n1"}, newLinKV())
+
+	if reply := tn.call(t, map[string]any{"type": "generate"}); reply["type"] != "generate_ok" {
+		t.Fatalf("got %v", reply)
+	} else if _, ok := reply["id"].(json.Number); !ok {
+		t.Errorf("default format: got ID %#v, want a number", reply["id"])
+	}
+
+	reply := tn.call(t, map[string]any{"type": "generate", "format": "uuidv7"})
+	if d, err := idgen.Decode(reply["id"], []string{"n0", "n1"}, time.Now()); err != nil || d.Format != "uuidv7" || d.Node != "n1" {
+		t.Errorf("uuidv7: got %v, decoded to %+v, %v", reply, d, err)
+	}
+
+	if reply := tn.call(t, map[string]any{"type": "generate", "format": "uuidv4"}); reply["code"] != json.Number("12") {
+		t.Errorf("unknown format: got %v, want a malformed-request error", reply)
+	}
+}
+
+// TestInspect_Malformed checks that an inspect request without a decodable ID gets a
+// malformed-request error rather than a crash.
+func TestInspect_Malformed(t *testing.T) {
+	tn := startNode(t, testConfig("snowflake"), "n1", []string{"n0", "n1"}, newLinKV())
+
+	for _, body := range []map[string]any{
+		{"type": "inspect"},
+		{"type": "inspect", "id": map[string]any{"id": 1}},
+		{"type": "inspect", "id": []int{1, 2}},
+		{"type": "inspect", "id": "not-an-id"},
+	} {
+		if reply := tn.call(t, body); reply["code"] != json.Number("12") {
+			t.Errorf("%v: got %v, want a malformed-request error", body, reply)
+		}
+	}
+}
+
+// TestGenerateBatch checks that a generate_batch request returns count unique IDs in the
+// requested format, and that out-of-range counts are rejected.
+func TestGenerateBatch(t *testing.T) {
+	const maxBatch = 5000
+	cfg := testConfig("guid")
+	cfg.maxBatch = maxBatch
+	tn := startNode(t, cfg, "n0", []string{"n0"}, newLinKV())
+
+	for _, format := range formats {
+		seen := make(map[string]bool)
+		for i := 0; i < 3; i++ {
+			reply := tn.call(t, map[string]any{"type": "generate_batch", "count": maxBatch, "format": format})
+			ids, _ := reply["ids"].([]any)
+			if reply["type"] != "generate_batch_ok" || len(ids) != maxBatch {
+				t.Fatalf("%s: got %s reply with %d IDs, want %d", format, reply["type"], len(ids), maxBatch)
+			}
+
+			for _, id := range ids {
+				key := fmt.Sprint(id)
+				if seen[key] {
+					t.Fatalf("%s: duplicate ID %v", format, id)
+				}
+				seen[key] = true
+			}
+		}
+	}
+
+	for _, count := range []any{0, -1, maxBatch + 1, 1.5, "10", nil} {
+		reply := tn.call(t, map[string]any{"type": "generate_batch", "count": count})
+		if reply["code"] != json.Number("12") {
+			t.Errorf("count %v: got %v, want a malformed-request error", count, reply)
+		}
+	}
+}
+
+// TestGenerate_Dense runs two nodes sharing lin-kv and checks that together they hand out the
+// IDs 1 to N, then partitions lin-kv away and checks that they refuse to issue IDs once their
+// blocks run out.
+func TestGenerate_Dense(t *testing.T) {
+	cfg := testConfig("dense")
+	cfg.blockMin, cfg.blockMax, cfg.timeout = 5, 5, 100*time.Millisecond
+
+	kv := newLinKV()
+	nodes := []*testNode{
+		startNode(t, cfg, "n0", []string{"n0", "n1"}, kv),
+		startNode(t, cfg, "n1", []string{"n0", "n1"}, kv),
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		for _, tn := range nodes {
+			reply := tn.call(t, map[string]any{"type": "generate"})
+			id := fmt.Sprint(reply["id"])
+			if reply["type"] != "generate_ok" || seen[id] {
+				t.Fatalf("got %v, after %d IDs", reply, len(seen))
+			}
+			seen[id] = true
+		}
+	}
+	for id := 1; id <= 40; id++ {
+		if !seen[fmt.Sprint(id)] {
+			t.Errorf("ID %d was never handed out", id)
+		}
+	}
+
+	// Each node has used up its block, so a partition stops both.
+	kv.cut.Store(true)
+	for _, tn := range nodes {
+		if reply := tn.call(t, map[string]any{"type": "generate"}); reply["code"] != json.Number("11") {
+			t.Errorf("during a partition: got %v, want a temporarily-unavailable error", reply)
+		}
+	}
+
+	kv.cut.Store(false)
+	reply := nodes[0].call(t, map[string]any{"type": "generate_batch", "count": 3})
+	if ids, _ := reply["ids"].([]any); len(ids) != 3 || seen[fmt.Sprint(ids[0])] {
+		t.Errorf("after the partition healed: got %v", reply)
+	}
+}
+
+// TestGenerate_Unique runs four nodes, half of them borrowing, and checks that the IDs they
+// generate in each format never repeat across the cluster, and that each node's timestamped IDs
+// increase.
+func TestGenerate_Unique(t *testing.T) {
+	const rounds, batch = 8, 1000
+	ids := []string{"n0", "n1", "n2", "n3"}
+
+	for _, format := range formats {
+		t.Run(format, func(t *testing.T) {
+			kv := newLinKV()
+			nodes := make([]*testNode, len(ids))
+			for i, id := range ids {
+				cfg := testConfig(format)
+				cfg.borrow = i%2 == 0
+				nodes[i] = startNode(t, cfg, id, ids, kv)
+			}
+
+			seen := make(map[string]string, rounds*batch*len(ids))
+			last := make([]any, len(ids))
+			for round := 0; round < rounds; round++ {
+				for i, tn := range nodes {
+					reply := tn.call(t, map[string]any{"type": "generate_batch", "count": batch})
+					batchIDs, _ := reply["ids"].([]any)
+					if len(batchIDs) != batch {
+						t.Fatalf("%s: got %v", ids[i], reply["type"])
+					}
+
+					for _, id := range batchIDs {
+						key := fmt.Sprint(id)
+						if node, ok := seen[key]; ok {
+							t.Fatalf("%s generated %v, already generated by %s", ids[i], id, node)
+						}
+						seen[key] = ids[i]
+
+						if format != "guid" && last[i] != nil && !increases(t, last[i], id) {
+							t.Fatalf("%s: ID %v after %v", ids[i], id, last[i])
+						}
+						last[i] = id
+					}
+				}
+			}
+		})
+	}
+}
+
+// increases reports whether ID b sorts after ID a, where both are integers or both strings.
+func increases(t *testing.T, a, b any) bool {
+	t.Helper()
+
+	if s, ok := a.(string); ok {
+		return s < b.(string)
+	}
+	x, err := a.(json.Number).Int64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	y, err := b.(json.Number).Int64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x < y
+}
+
+// TestGenerate_Uninitialized checks that a node refuses to generate IDs, rather than crashing,
+// before it is initialized and after an init that failed, and that it refuses to inspect IDs
+// before it knows the cluster.
+func TestGenerate_Uninitialized(t *testing.T) {
+	tn := runNode(t, testConfig("snowflake"), newLinKV())
+
+	requests := []map[string]any{{"type": "generate"}, {"type": "generate_batch", "count": 3}}
+	check := func(when string) {
+		for _, body := range requests {
+			if reply := tn.call(t, body); reply["code"] != json.Number("11") {
+				t.Errorf("%s %s: got %v, want a temporarily-unavailable error", when, body["type"], reply)
+			}
+		}
+	}
+
+	check("before init")
+	if reply := tn.call(t, map[string]any{"type": "inspect", "id": idgen.EncodeULID(time.Now().UnixMilli(), 5, 0, 0)}); reply["code"] != json.Number("11") {
+		t.Errorf("inspect before init: got %v, want a temporarily-unavailable error", reply)
+	}
+	if reply := tn.call(t, map[string]any{"type": "init", "node_id": "n3", "node_ids": []string{"n0", "n1"}}); reply["type"] != "error" {
+		t.Fatalf("init of a node outside the cluster: got %v", reply)
+	}
+	check("after a failed init")
+}
+
+func TestNewGenerators(t *testing.T) {
+	cfg := testConfig("guid")
+	if _, err := newGenerators("n3", []string{"n0", "n1"}, nil, cfg); err == nil {
+		t.Error("node outside the cluster accepted")
+	}
+
+	// A cluster too large for the timestamped formats can still generate GUIDs.
+	ids := make([]string, idgen.MaxNodes+1)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("n%d", i)
+	}
+	gens, err := newGenerators(ids[idgen.MaxNodes], ids, nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rpcErr *maelstrom.RPCError
+	if _, err := gens.next(context.Background(), "uuidv4", 1); !errors.As(err, &rpcErr) || rpcErr.Code != maelstrom.MalformedRequest {
+		t.Errorf("unknown format: got %v, want a malformed-request error", err)
+	}
+	if _, err := gens.next(context.Background(), "ulid", 1); !errors.As(err, &rpcErr) || rpcErr.Code != maelstrom.NotSupported {
+		t.Errorf("node index %d: got %v, want a not-supported error", idgen.MaxNodes, err)
+	}
+	if _, err := gens.next(context.Background(), "guid", 1); err != nil {
+		t.Errorf("guid with node index %d: %v", idgen.MaxNodes, err)
+	}
+}
+
+func TestRunInspect(t *testing.T) {
+	ms := time.Now().UnixMilli()
+	good, bad := idgen.EncodeUUIDv7(ms, 1, 2, 3), "n0_x"
+
+	var out bytes.Buffer
+	if err := runInspect([]string{"-nodes", "n0,n1", good, bad}, &out); err == nil {
+		t.Error("runInspect succeeded with an invalid ID")
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), out.String())
+	}
+
+	var d idgen.Decoded
+	if err := json.Unmarshal([]byte(lines[0]), &d); err != nil || d.Node != "n1" || d.Sequence != 2 {
+		t.Errorf("first line %s decodes to %+v, %v", lines[0], d, err)
+	}
+	if !strings.HasPrefix(lines[1], bad+": ") {
+		t.Errorf("second line is %q, want an error about %s", lines[1], bad)
+	}
+}